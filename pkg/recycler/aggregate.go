@@ -0,0 +1,124 @@
+package recycler
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// Aggregate counts logs grouped by fields and outputs a summary per group after each window instead of the logs,
+// to downsample high volume logs like access logs at the edge
+type Aggregate struct {
+	Window     time.Duration
+	By         []string // fields to group by, summaries have these fields and count
+	When       string   // only aggregate logs where this https://expr-lang.org expression is true (default all)
+	whenParsed *vm.Program
+	Keep       bool // also output the aggregated logs
+	lock       sync.Mutex
+	groups     map[string]*aggregateGroup
+	order      []string // fingerprints in the order they were first seen, so summaries are output in that order
+	stop       chan struct{}
+	done       sync.WaitGroup
+}
+
+type aggregateGroup struct {
+	values []string
+	count  int
+}
+
+func (a *Aggregate) validate() error {
+	if a.Window <= 0 {
+		return fmt.Errorf("aggregate.window must be set")
+	}
+	if len(a.By) == 0 {
+		return fmt.Errorf("aggregate.by must be set")
+	}
+	if a.When != "" {
+		program, err := compileCondition(a.When, "aggregate.when")
+		if err != nil {
+			return err
+		}
+		a.whenParsed = program
+	}
+	a.groups = map[string]*aggregateGroup{}
+	return nil
+}
+
+// Add counts the log, false when it is not aggregated because it does not match when
+func (a *Aggregate) Add(log *OrderedMap) bool {
+	if a.whenParsed != nil && !matchesCondition(a.whenParsed, log, nil, nil) {
+		return false
+	}
+	fingerprint := fieldsFingerprint(log, a.By)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	group, found := a.groups[fingerprint]
+	if !found {
+		values := make([]string, len(a.By))
+		for i, field := range a.By {
+			values[i] = log.values[field]
+		}
+		group = &aggregateGroup{values: values}
+		a.groups[fingerprint] = group
+		a.order = append(a.order, fingerprint)
+	}
+	group.count++
+	return true
+}
+
+// Start outputting summaries after every window
+func (a *Aggregate) Start(config *Config, output func(*OrderedMap)) {
+	a.stop = make(chan struct{})
+	a.done.Add(1)
+	go func() {
+		defer a.done.Done()
+		ticker := time.NewTicker(a.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.flush(config, output)
+			case <-a.stop:
+				a.flush(config, output)
+				return
+			}
+		}
+	}()
+}
+
+// Stop outputs the summaries of the current window
+func (a *Aggregate) Stop() {
+	close(a.stop)
+	a.done.Wait()
+}
+
+func (a *Aggregate) flush(config *Config, output func(*OrderedMap)) {
+	a.lock.Lock()
+	groups, order := a.groups, a.order
+	a.groups = map[string]*aggregateGroup{}
+	a.order = nil
+	a.lock.Unlock()
+
+	now := time.Now()
+	for _, fingerprint := range order {
+		group := groups[fingerprint]
+		summary := acquireOrderedMap()
+		if config.timestampKeySet {
+			summary.Set(config.TimestampKey, now.Format(timeFormat))
+		}
+		if config.levelKeySet {
+			summary.Set(config.LevelKey, "INFO")
+		}
+		summary.Set(config.MessageKey, "aggregated "+strconv.Itoa(group.count)+" logs")
+		for i, field := range a.By {
+			summary.Set(field, group.values[i])
+		}
+		summary.Set("count", strconv.Itoa(group.count))
+		summary.SetType("count", "int")
+		output(summary)
+	}
+}
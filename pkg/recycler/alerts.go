@@ -0,0 +1,128 @@
+package recycler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Alert posts to a webhook when a named pattern matched count times within the window
+type Alert struct {
+	Pattern        string
+	Count          int
+	Window         time.Duration
+	Url            string
+	Template       string // payload, default is for slack
+	templateParsed *template.Template
+	Samples        int // recent lines attached to the alert (default 5)
+	lock           sync.Mutex
+	matches        []time.Time
+	samples        []string
+	firedAt        time.Time
+	client         *http.Client
+	sending        sync.WaitGroup
+}
+
+const defaultAlertTemplate = `{"text":{{json .text}}}`
+
+func (a *Alert) validate(location string, patternNames []string) error {
+	if !contains(patternNames, a.Pattern) {
+		return fmt.Errorf("%v.pattern must be the name of a pattern but was %v", location, a.Pattern)
+	}
+	if a.Count < 1 || a.Window <= 0 || a.Url == "" {
+		return fmt.Errorf("%v.count, %v.window and %v.url must be set", location, location, location)
+	}
+	if a.Samples == 0 {
+		a.Samples = 5
+	}
+	var err error
+	a.templateParsed, err = parseAlertTemplate(location, a.Template)
+	return err
+}
+
+func parseAlertTemplate(location string, source string) (*template.Template, error) {
+	if source == "" {
+		source = defaultAlertTemplate
+	}
+	return template.New(location + ".template").
+		Option("missingkey=zero").
+		Funcs(template.FuncMap{"json": jsonString}).
+		Parse(source)
+}
+
+func (a *Alert) Start() {
+	a.client = &http.Client{Timeout: 10 * time.Second}
+}
+
+// Stop waits for alerts that are being sent
+func (a *Alert) Stop() {
+	a.sending.Wait()
+}
+
+// Match records a matching line and sends the alert when the threshold is reached, at most once per window
+func (a *Alert) Match(line string, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// forget matches outside the window
+	kept := a.matches[:0]
+	for _, match := range a.matches {
+		if now.Sub(match) < a.Window {
+			kept = append(kept, match)
+		}
+	}
+	a.matches = append(kept, now)
+
+	a.samples = append(a.samples, line)
+	if len(a.samples) > a.Samples {
+		a.samples = a.samples[1:]
+	}
+
+	if len(a.matches) < a.Count || now.Sub(a.firedAt) < a.Window {
+		return
+	}
+	a.firedAt = now
+
+	data := map[string]interface{}{
+		"pattern": a.Pattern,
+		"count":   len(a.matches),
+		"window":  a.Window.String(),
+		"samples": append([]string{}, a.samples...),
+	}
+	data["text"] = "pattern " + a.Pattern + " matched " + strconv.Itoa(len(a.matches)) + " times in " + a.Window.String() +
+		"\n" + strings.Join(a.samples, "\n")
+
+	a.sending.Add(1)
+	go func() {
+		defer a.sending.Done()
+		a.send(data)
+	}()
+}
+
+func (a *Alert) send(data map[string]interface{}) {
+	postAlert(a.client, a.Url, a.templateParsed, data)
+}
+
+func postAlert(client *http.Client, url string, templateParsed *template.Template, data map[string]interface{}) {
+	var body bytes.Buffer
+	if err := templateParsed.Execute(&body, data); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: rendering alert: %v\n", err.Error()) // untested section
+		return
+	}
+	response, err := client.Post(url, "application/json", &body)
+	if err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: sending alert: %v\n", err.Error())
+		return
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: sending alert: status %v\n", response.StatusCode)
+	}
+}
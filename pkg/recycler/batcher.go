@@ -0,0 +1,137 @@
+package recycler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Batcher collects items and sends them when the batch is full or after a wait,
+// adding blocks when sending falls behind to apply backpressure
+type Batcher[T any] struct {
+	items  chan T
+	buffer *DiskBuffer
+	done   sync.WaitGroup
+}
+
+func NewBatcher[T any](size int, wait time.Duration, send func([]T)) *Batcher[T] {
+	return NewBufferedBatcher(size, wait, nil, func(batch []T) error {
+		send(batch)
+		return nil
+	})
+}
+
+// 0 uses the default of the sink, a negative wait cannot tick
+func validateBatchWait(location string, wait time.Duration) error {
+	if wait < 0 {
+		return fmt.Errorf("%v.batchWait must be more than 0 but was %v", location, wait)
+	}
+	return nil
+}
+
+// NewBufferedBatcher writes items to the disk buffer instead of blocking when sending falls behind
+// and when send returns an error because the batch should be sent again later,
+// buffered items are sent again after each wait, nil buffer means no buffering
+func NewBufferedBatcher[T any](size int, wait time.Duration, buffer *DiskBuffer, send func([]T) error) *Batcher[T] {
+	b := &Batcher[T]{items: make(chan T, size), buffer: buffer}
+	b.done.Add(1)
+	go func() {
+		defer b.done.Done()
+		batch := make([]T, 0, size)
+		ticker := time.NewTicker(wait)
+		defer ticker.Stop()
+		for {
+			select {
+			case item, open := <-b.items:
+				if !open {
+					if len(batch) != 0 {
+						b.send(batch, send)
+					}
+					b.resend(size, send)
+					return
+				}
+				batch = append(batch, item)
+				if len(batch) >= size {
+					b.send(batch, send)
+					batch = make([]T, 0, size)
+				}
+			case <-ticker.C:
+				if len(batch) != 0 {
+					b.send(batch, send)
+					batch = make([]T, 0, size)
+				}
+				b.resend(size, send)
+			}
+		}
+	}()
+	return b
+}
+
+func (b *Batcher[T]) Add(item T) {
+	if b.buffer == nil {
+		b.items <- item
+		return
+	}
+	select {
+	case b.items <- item:
+	default:
+		b.store(item)
+	}
+}
+
+// Stop sends what is left and waits for sending to finish
+func (b *Batcher[T]) Stop() {
+	close(b.items)
+	b.done.Wait()
+	if b.buffer != nil {
+		b.buffer.Close()
+	}
+}
+
+// buffer the batch when it could not be sent
+func (b *Batcher[T]) send(batch []T, send func([]T) error) {
+	if err := send(batch); err != nil && b.buffer != nil {
+		for _, item := range batch {
+			b.store(item)
+		}
+	}
+}
+
+func (b *Batcher[T]) store(item T) {
+	line, err := json.Marshal(item)
+	if err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: buffering: %v\n", err.Error())
+		return
+	}
+	b.buffer.Write(line)
+}
+
+// send buffered items in batches, keeping the ones that could not be sent
+func (b *Batcher[T]) resend(size int, send func([]T) error) {
+	if b.buffer == nil {
+		return
+	}
+	lines := b.buffer.Take()
+	for start := 0; start < len(lines); start += size {
+		end := min(start+size, len(lines))
+		batch := make([]T, 0, end-start)
+		for _, line := range lines[start:end] {
+			var item T
+			if err := json.Unmarshal(line, &item); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Error: reading buffer %v: %v\n", b.buffer.Path, err.Error())
+				continue
+			}
+			batch = append(batch, item)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := send(batch); err != nil {
+			b.buffer.Restore(lines[start:])
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package recycler
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// replay a file through the configured pipeline without sinks and report throughput and per-pattern cost,
+// to compare config changes quantitatively
+func runBench(args []string) int {
+	set, flags := newFlagSet("logrecycler bench", "replay a log file and report throughput and per-pattern cost\n"+
+		"usage: logrecycler bench -input sample.log [-config logrecycler.yaml] [-set key=value]\n")
+	inputPath := set.String("input", "", "Log file to replay")
+	if code, done := parseFlags(set, args); done {
+		return code
+	}
+	if *inputPath == "" || set.NArg() != 0 {
+		// untested section
+		set.Usage()
+		return exitFailure
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		return exitFailure // untested section
+	}
+	disableMetrics(config)
+	config.patternStats.timed = true
+	config.patternsCombined = nil // time each pattern on its own
+
+	lines, err := readBenchLines(*inputPath, config)
+	if err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+		return exitFailure
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	started := time.Now()
+	for _, line := range lines {
+		for _, log := range processLine(line, config) {
+			_ = formatLine(log, config)
+			releaseOrderedMap(log)
+		}
+	}
+	duration := time.Since(started)
+	runtime.ReadMemStats(&after)
+
+	count := len(lines)
+	if count == 0 {
+		count = 1 // avoid dividing by 0, all values are 0 anyway
+	}
+	report := NewOrderedMap()
+	report.Set("lines", fmt.Sprint(len(lines)))
+	report.Set("duration", duration.String())
+	report.Set("lines_per_second", fmt.Sprintf("%.0f", float64(len(lines))/duration.Seconds()))
+	report.Set("allocs_per_line", fmt.Sprint((after.Mallocs-before.Mallocs)/uint64(count)))
+	report.Set("bytes_per_line", fmt.Sprint((after.TotalAlloc-before.TotalAlloc)/uint64(count)))
+	fmt.Println(report.ToLogfmt())
+	fmt.Print(config.patternStats.Report())
+	return 0
+}
+
+// read everything before measuring so disk speed does not skew the results
+func readBenchLines(path string, config *Config) ([]Line, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	channel := make(chan Line)
+	go func() {
+		readLines(file, "", channel, config)
+		close(channel)
+	}()
+	var lines []Line
+	for line := range channel {
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,118 @@
+package recycler
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validate the config without processing anything, printing what it would produce
+func runCheck(args []string) int {
+	set, flags := newFlagSet("logrecycler check", "validate the config and print the labels and keys of each pattern\n"+
+		"usage: logrecycler check [-config logrecycler.yaml] [-set key=value]\n")
+	if code, done := parseFlags(set, args); done {
+		return code
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		return exitFailure
+	}
+	if config.Prometheus != nil {
+		if err := config.Prometheus.register(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: prometheus: %v\n", err.Error())
+			return exitFailure
+		}
+	}
+
+	report := NewOrderedMap()
+	report.Set("config", flags.Path)
+	labels := config.possibleLabels()
+	sort.Strings(labels)
+	report.Set("labels", strings.Join(labels, ","))
+	report.Set("keys", strings.Join(config.outputKeys(nil), ","))
+	fmt.Println(report.ToLogfmt())
+	for i := range config.Patterns {
+		pattern := &config.Patterns[i]
+		line := NewOrderedMap()
+		line.Set("pattern", strconv.Itoa(i))
+		if pattern.Name != "" {
+			line.Set("name", pattern.Name)
+		}
+		if pattern.Discard {
+			line.Set("discard", "true")
+		} else {
+			line.Set("keys", strings.Join(config.outputKeys(pattern), ","))
+		}
+		fmt.Println(line.ToLogfmt())
+	}
+	return 0
+}
+
+// keys of logs in output order, for lines matching the pattern or no pattern when nil
+// keys from json, headers or conditional steps are not known upfront
+func (c *Config) outputKeys(pattern *Pattern) []string {
+	fields := []string{}
+	if c.timestampKeySet {
+		fields = append(fields, c.TimestampKey)
+	}
+	if c.levelKeySet {
+		fields = append(fields, c.LevelKey)
+	}
+	fields = append(fields, c.MessageKey)
+	if c.streamKeySet {
+		fields = append(fields, c.StreamKey)
+	}
+	if c.Split != nil {
+		for _, key := range []string{c.Split.IdKey, c.Split.IndexKey} {
+			if key != "" {
+				fields = append(fields, key)
+			}
+		}
+	}
+	if c.Kubernetes != nil {
+		fields = append(fields, c.Kubernetes.keys...)
+	}
+	fields = append(fields, sortedMapKeys(c.Add)...)
+	if c.VersionKey != "" {
+		fields = append(fields, c.VersionKey)
+	}
+	for _, step := range c.Preprocess {
+		if step.Replace == nil {
+			addCaptureNames(step.regexParsed, &fields)
+		}
+	}
+
+	if pattern != nil {
+		fields = append(fields, pattern.outputCaptures()...)
+		added := keys(pattern.Add)
+		sort.Strings(added) // merged in key order
+		fields = append(fields, added...)
+		fields = renameAndRemove(fields, pattern.Rename, pattern.Remove)
+	}
+
+	if c.DebugKey != "" {
+		fields = append(fields, c.DebugKey)
+	}
+	fields = unique(renameAndRemove(fields, c.Rename, c.Remove))
+	if c.outputFieldsSet != nil {
+		fields = c.selectFields(fields)
+	}
+	return fields
+}
+
+// fields in outputFields order, followed by the extra key when fields are left over
+func (c *Config) selectFields(fields []string) []string {
+	var selected []string
+	for _, field := range c.OutputFields {
+		if contains(fields, field) {
+			selected = append(selected, field)
+		}
+	}
+	if c.ExtraKey != "" && len(selected) != len(fields) {
+		selected = append(selected, c.ExtraKey)
+	}
+	return selected
+}
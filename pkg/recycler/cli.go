@@ -0,0 +1,138 @@
+package recycler
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const usageText = "pipe logs to logrecycler to convert them into json logs with custom tags\n" +
+	"alternatively tell it what command to execute with `-- command`\n" +
+	"configure with logrecycler.yaml, -config or LOGRECYCLER_CONFIG and override settings with -set key=value\n" +
+	"commands: run (default), check, test, bench, replay, see `logrecycler <command> -help`\n" +
+	"for more info see https://github.com/grosser/logrecycler\n"
+
+// ConfigFlags are the flags of all commands that read the config
+type ConfigFlags struct {
+	Path      string
+	Overrides Overrides
+}
+
+// Overrides are `-set key=value` flags that override the config, nested keys are separated by dots
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *Overrides) Set(value string) error {
+	if key, _, found := strings.Cut(value, "="); !found || key == "" {
+		return fmt.Errorf("must be key=value")
+	}
+	*o = append(*o, value)
+	return nil
+}
+
+// flag set that prints usage to stderr and returns errors instead of exiting
+func newFlagSet(name string, usage string) (*flag.FlagSet, *ConfigFlags) {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(os.Stderr)
+	set.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "logrecycler %v\n%v", Version, usage)
+		set.PrintDefaults()
+	}
+	flags := &ConfigFlags{}
+	set.StringVar(&flags.Path, "config", defaultConfigPath(), "Config file or directory of yaml files to merge, can be set via LOGRECYCLER_CONFIG")
+	set.Var(&flags.Overrides, "set", "Override a config setting like `prometheus.port=9090`, can be repeated")
+	return set, flags
+}
+
+// parse flags, returning the exit code when the command should stop, -help is not a failure
+func parseFlags(set *flag.FlagSet, args []string) (int, bool) {
+	err := set.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0, true
+	}
+	if err != nil {
+		return exitFailure, true // flag printed the error and usage
+	}
+	return 0, false
+}
+
+// read the config, reporting errors
+func (f *ConfigFlags) load() (*Config, error) {
+	config, err := NewConfig(f.Path, f.Overrides...)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v: %v\n", f.Path, err.Error())
+	}
+	return config, err
+}
+
+// do not report anything when only trying out the config
+func disableMetrics(config *Config) {
+	config.Prometheus = nil
+	config.Statsd = nil
+	config.OtlpMetrics = nil
+	config.telemetry = nil
+	config.alertsByPattern = nil
+	config.rateAlertsByPattern = nil
+	config.Recent = nil
+}
+
+// process the lines given as arguments or read from stdin and print the resulting logs without sending them anywhere,
+// to try out patterns
+func runTest(args []string) int {
+	set, flags := newFlagSet("logrecycler test", "process the given lines or stdin and print the resulting logs without sending them anywhere\n"+
+		"usage: logrecycler test [-config logrecycler.yaml] [-set key=value] [line ...]\n")
+	if code, done := parseFlags(set, args); done {
+		return code
+	}
+	config, err := flags.load()
+	if err != nil {
+		return exitFailure
+	}
+	disableMetrics(config)
+	if config.ExecFilter != nil {
+		defer config.ExecFilter.Stop()
+	}
+	if config.Wasm != nil {
+		defer config.Wasm.Stop()
+	}
+
+	if config.Aggregate != nil {
+		config.Aggregate.Start(config, func(log *OrderedMap) {
+			fmt.Println(formatLine(log, config))
+			releaseOrderedMap(log)
+		})
+		defer config.Aggregate.Stop()
+	}
+
+	if config.Dedup != nil {
+		config.Dedup.Start(config, func(log *OrderedMap) {
+			fmt.Println(formatLine(log, config))
+			releaseOrderedMap(log)
+		})
+		defer config.Dedup.Stop()
+	}
+
+	lines := make(chan Line)
+	go func() {
+		defer close(lines)
+		if set.NArg() == 0 {
+			readLines(os.Stdin, "", lines, config)
+			return
+		}
+		for _, text := range set.Args() {
+			lines <- Line{Text: text}
+		}
+	}()
+	for line := range lines {
+		for _, log := range processLine(line, config) {
+			fmt.Println(formatLine(log, config))
+			releaseOrderedMap(log)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,1170 @@
+package recycler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+type Pattern struct {
+	Name               string
+	Regex              string
+	regexParsed        *regexp.Regexp
+	RegexNot           string `yaml:"regexNot"` // only match when this does not match
+	regexNotParsed     *regexp.Regexp
+	AllOf              []string `yaml:"allOf"` // only match when all of these match too
+	allOfParsed        []*regexp.Regexp
+	AnyOf              []string `yaml:"anyOf"` // only match when one of these matches too
+	anyOfParsed        []*regexp.Regexp
+	IgnoreCase         bool              `yaml:"ignoreCase"` // compile all regexes of the pattern case-insensitive
+	Dotall             bool              // let . match newlines in all regexes of the pattern
+	Format             string            // built-in regex for access logs
+	Field              string            // match the regex against this field (default message)
+	CaptureMap         map[string]string `yaml:"captureMap"` // output captures under a different name
+	Captures           []string          // only output these captures (default all)
+	captureNames       []string          // output name per submatch, empty to skip
+	Contains           string            // only try the regex when the message contains this
+	literal            string            // contains or the literal prefix of the regex
+	Discard            bool
+	Kv                 KeyValues // parse key=value pairs of the message into fields
+	Add                map[string]string
+	addTemplated       []string // add keys with ${field} references
+	Level              string
+	levelSet           bool
+	IgnoreMetricLabels []string   `yaml:"ignoreMetricLabels"`
+	SampleRate         *float32   `yaml:"sampleRate"`
+	Sample             int        // only output every Nth match, metrics still count all
+	RateLimit          *RateLimit `yaml:"rateLimit"`
+	DiscardBelow       string     `yaml:"discardBelow"`
+	discardBelowRank   int
+	When               string
+	whenParsed         *vm.Program
+	Rename             map[string]string
+	Remove             []string
+	Replace            []Replacement
+	Types              map[string]string // field -> int, float or bool in json output
+	Lua                string            // script that transforms logs matching the pattern
+	lua                *LuaScript
+	Statsd             *PatternStatsd // report matches as their own statsd metric
+	StatsdEvent        *StatsdEvent   `yaml:"statsdEvent"`
+}
+
+type Redaction struct {
+	Regex       string
+	regexParsed *regexp.Regexp
+	Replace     *string
+}
+
+// Replacement replaces all matches in the given fields
+type Replacement struct {
+	Regex       string
+	regexParsed *regexp.Regexp
+	Replace     string
+	Fields      []string // default message
+}
+
+// PreprocessStep captures from the message, or replaces all matches in the message when replace is set
+type PreprocessStep struct {
+	Regex       string
+	regexParsed *regexp.Regexp
+	Replace     *string
+}
+
+// PreprocessSteps are applied in order, accepts a single regex or a list of steps
+type PreprocessSteps []PreprocessStep
+
+func (p *PreprocessSteps) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var regex string
+	if err := unmarshal(&regex); err == nil {
+		if regex == "" {
+			*p = PreprocessSteps{}
+		} else {
+			*p = PreprocessSteps{{Regex: regex}}
+		}
+		return nil
+	}
+
+	var steps []PreprocessStep
+	if err := unmarshal(&steps); err != nil {
+		return err
+	}
+	*p = steps
+	return nil
+}
+
+type Config struct {
+	Inputs               []Input
+	Workers              int
+	MatchTimeout         time.Duration `yaml:"matchTimeout"`
+	DebugKey             string        `yaml:"debugKey"`
+	VersionKey           string        `yaml:"versionKey"`  // add the logrecycler version to every log and prometheus metric
+	SelfMetrics          bool          `yaml:"selfMetrics"` // report lines, bytes and processing time of logrecycler itself
+	telemetry            *Telemetry
+	UnknownKeys          string `yaml:"unknownKeys"`
+	SinkFailure          string `yaml:"sinkFailure"`
+	Unmatched            string
+	OutputFormat         string `yaml:"outputFormat"`
+	logfmt               bool
+	OutputTemplate       string   `yaml:"outputTemplate"`
+	NestedOutput         bool     `yaml:"nestedOutput"`
+	OutputFields         []string `yaml:"outputFields"` // only output these fields in this order
+	outputFieldsSet      map[string]bool
+	ExtraKey             string `yaml:"extraKey"` // collect fields not in outputFields under this key instead of dropping them
+	Ecs                  bool
+	outputTemplateParsed *template.Template
+	BufferSize           int           `yaml:"bufferSize"`
+	FlushInterval        time.Duration `yaml:"flushInterval"`
+	stdout               *Output
+	stderr               *Output
+	MaxLineLength        int  `yaml:"maxLineLength"`
+	TruncateLongLines    bool `yaml:"truncateLongLines"`
+	MaxFieldLength       int  `yaml:"maxFieldLength"` // cut longer values and append …
+	MaxEventBytes        int  `yaml:"maxEventBytes"`  // drop logs that are bigger when output
+	Prometheus           *Prometheus
+	Statsd               *Statsd
+	Loki                 *Loki
+	Elasticsearch        *Elasticsearch
+	Kafka                *Kafka
+	Splunk               *Splunk
+	Otlp                 *Otlp
+	OtlpMetrics          *OtlpMetrics `yaml:"otlpMetrics"`
+	Slack                *Slack
+	OutputFile           *OutputFile `yaml:"outputFile"`
+	Routes               []Route
+	Alerts               []Alert
+	alertsByPattern      map[string][]*Alert
+	RateAlerts           []RateAlert `yaml:"rateAlerts"`
+	rateAlertsByPattern  map[string][]*RateAlert
+	Metrics              []Metric
+	Glog                 HeaderFormats
+	glogSet              bool
+	Syslog               bool
+	Cri                  bool
+	Docker               bool
+	Heroku               bool
+	Kubernetes           *Kubernetes
+	Add                  map[string]string // add to every log, not used as metric labels
+	addTemplated         []string
+	Json                 string
+	Kv                   KeyValues
+	Csv                  *Csv
+	Split                *Split
+	jsonSet              bool
+	AllowMetricLabels    []string `yaml:"allowMetricLabels"`
+	TimestampKey         string   `yaml:"timestampKey"`
+	timestampKeySet      bool
+	TimestampParse       *TimestampParse `yaml:"timestampParse"`
+	LevelKey             string          `yaml:"levelKey"`
+	levelKeySet          bool
+	MessageKey           string `yaml:"messageKey"`
+	StreamKey            string `yaml:"streamKey"`
+	streamKeySet         bool
+	LevelMap             map[string]string `yaml:"levelMap"` // case-insensitive level -> normalized level
+	LevelKeywords        LevelKeywords     `yaml:"levelKeywords"`
+	MinLevel             string            `yaml:"minLevel"`
+	StderrLevels         []string          `yaml:"stderrLevels"` // write logs with these levels to stderr instead of stdout
+	stderrLevels         map[string]bool
+	minLevelRank         int
+	Patterns             []Pattern
+	patternsCombined     *patternSet
+	patternStats         *PatternStats
+	stats                *Stats
+	Redact               []Redaction
+	Hash                 *Hash
+	ExecFilter           *ExecFilter `yaml:"execFilter"`
+	Wasm                 *Wasm
+	Lua                  string // script that transforms all logs after patterns
+	lua                  *LuaScript
+	Rename               map[string]string
+	Remove               []string
+	Replace              []Replacement
+	Dedup                *Dedup
+	Aggregate            *Aggregate
+	Top                  *Top
+	Fingerprint          *Fingerprint
+	Recent               *Recent
+	Lookups              []Lookup
+	Geoip                []GeoIp
+	Preprocess           PreprocessSteps
+	StripAnsi            bool `yaml:"stripAnsi"`
+	StripAnsiCaptures    bool `yaml:"stripAnsiCaptures"`
+}
+
+var levelRanks = map[string]int{
+	"TRACE":    1,
+	"DEBUG":    2,
+	"INFO":     3,
+	"WARN":     4,
+	"WARNING":  4,
+	"ERROR":    5,
+	"FATAL":    6,
+	"CRITICAL": 6,
+	"PANIC":    6,
+}
+var timeFormat = time.RFC3339
+var metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// labels of the metrics logrecycler reports about itself and of histograms and summaries
+var reservedPrometheusLabels = []string{"label", "le", "pattern", "quantile", "sink"}
+var envRegex = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+func NewConfig(path string, overrides ...string) (*Config, error) {
+	// read config
+	config, err := readConfig(path, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Ecs {
+		applyEcs(config)
+	}
+
+	// we always need a message key
+	if config.MessageKey == "" {
+		config.MessageKey = "message"
+	}
+
+	// optimizations to avoid doing multiple times
+	for i := range config.Patterns {
+		if format := config.Patterns[i].Format; format != "" {
+			if config.Patterns[i].Regex != "" {
+				return nil, fmt.Errorf("patterns[%d] must set one of regex or format", i)
+			}
+			regex, found := accessLogFormats[format]
+			if !found {
+				return nil, fmt.Errorf("patterns[%d].format must be one of %v but was %v", i, strings.Join(accessLogFormatNames, ", "), format)
+			}
+			config.Patterns[i].Regex = regex
+		}
+		config.Patterns[i].regexParsed, err = compileRegex(config.Patterns[i].flags()+config.Patterns[i].Regex, "patterns["+strconv.Itoa(i)+"].regex")
+		if err != nil {
+			return nil, err
+		}
+		if err := config.Patterns[i].compileCaptures("patterns[" + strconv.Itoa(i) + "]"); err != nil {
+			return nil, err
+		}
+		if err := config.Patterns[i].compileConditions("patterns[" + strconv.Itoa(i) + "]"); err != nil {
+			return nil, err
+		}
+		config.Patterns[i].levelSet = (config.Patterns[i].Level != "")
+		config.Patterns[i].addTemplated = templatedKeys(config.Patterns[i].Add)
+		config.Patterns[i].lua, err = compileLua(config.Patterns[i].Lua, "patterns["+strconv.Itoa(i)+"].lua")
+		if err != nil {
+			return nil, err
+		}
+		if !config.Patterns[i].IgnoreCase {
+			config.Patterns[i].literal = config.Patterns[i].Contains
+		}
+		if config.Patterns[i].literal == "" {
+			config.Patterns[i].literal, _ = config.Patterns[i].regexParsed.LiteralPrefix()
+		}
+
+		if config.Patterns[i].When != "" {
+			config.Patterns[i].whenParsed, err = compileCondition(config.Patterns[i].When, "patterns["+strconv.Itoa(i)+"].when")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		rank, err := parseLevelRank(config.Patterns[i].DiscardBelow, config, "patterns["+strconv.Itoa(i)+"].discardBelow")
+		if err != nil {
+			return nil, err
+		}
+		config.Patterns[i].discardBelowRank = rank
+
+		if err := compileReplacements(config.Patterns[i].Replace, config, "patterns["+strconv.Itoa(i)+"].replace"); err != nil {
+			return nil, err
+		}
+
+		for field, kind := range config.Patterns[i].Types {
+			if kind != "int" && kind != "float" && kind != "bool" {
+				return nil, fmt.Errorf("patterns[%d].types.%v must be int, float or bool but was %v", i, field, kind)
+			}
+		}
+
+		if config.Patterns[i].Sample < 0 {
+			return nil, fmt.Errorf("patterns[%d].sample must be 0 or more but was %d", i, config.Patterns[i].Sample)
+		}
+
+		if config.Patterns[i].RateLimit != nil {
+			if err := config.Patterns[i].RateLimit.validate("patterns[" + strconv.Itoa(i) + "].rateLimit"); err != nil {
+				return nil, err
+			}
+		}
+
+		if config.Patterns[i].Statsd != nil {
+			if err := config.Patterns[i].Statsd.validate("patterns["+strconv.Itoa(i)+"].statsd", config.Statsd); err != nil {
+				return nil, err
+			}
+		}
+
+		if config.Patterns[i].StatsdEvent != nil {
+			if err := config.Patterns[i].StatsdEvent.validate("patterns["+strconv.Itoa(i)+"].statsdEvent", config.Statsd, &config.Patterns[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		if config.Patterns[i].SampleRate != nil {
+			rate := *config.Patterns[i].SampleRate
+			if rate < 0.0 || rate > 1.0 {
+				return nil, fmt.Errorf("sample must be between 0.0 - 1.0 but was %f", rate)
+			}
+		}
+	}
+	config.patternStats = NewPatternStats(config.Patterns)
+	config.stats = NewStats()
+	// slow patterns can only be found when trying them one by one
+	if config.MatchTimeout == 0 {
+		if config.patternsCombined, err = combinePatterns(config.Patterns); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxLineLength == 0 {
+		config.MaxLineLength = 1024 * 1024
+	}
+
+	for i := range config.Inputs {
+		if err := config.Inputs[i].validate(fmt.Sprintf("inputs[%v]", i)); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.minLevelRank, err = parseLevelRank(config.MinLevel, config, "minLevel"); err != nil {
+		return nil, err
+	}
+
+	if config.LevelMap != nil {
+		if config.LevelKey == "" {
+			return nil, fmt.Errorf("levelMap requires levelKey to be set")
+		}
+		normalized := make(map[string]string, len(config.LevelMap))
+		for from, to := range config.LevelMap {
+			normalized[strings.ToUpper(from)] = to
+		}
+		config.LevelMap = normalized
+	}
+
+	config.addTemplated = templatedKeys(config.Add)
+
+	if len(config.OutputFields) != 0 {
+		config.outputFieldsSet = map[string]bool{}
+		for _, field := range config.OutputFields {
+			config.outputFieldsSet[field] = true
+		}
+		if config.outputFieldsSet[config.ExtraKey] {
+			return nil, fmt.Errorf("outputFields must not contain extraKey %v", config.ExtraKey)
+		}
+	} else if config.ExtraKey != "" {
+		return nil, fmt.Errorf("extraKey requires outputFields to be set")
+	}
+
+	if len(config.StderrLevels) != 0 {
+		if config.LevelKey == "" {
+			return nil, fmt.Errorf("stderrLevels requires levelKey to be set")
+		}
+		config.stderrLevels = map[string]bool{}
+		for _, level := range config.StderrLevels {
+			config.stderrLevels[strings.ToUpper(level)] = true
+		}
+	}
+
+	if config.LevelKeywords.Enabled {
+		if config.LevelKey == "" {
+			return nil, fmt.Errorf("levelKeywords requires levelKey to be set")
+		}
+		if err := config.LevelKeywords.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch config.OutputFormat {
+	case "", "json":
+	case "logfmt":
+		config.logfmt = true
+	default:
+		return nil, fmt.Errorf("outputFormat must be json or logfmt but was %v", config.OutputFormat)
+	}
+
+	if config.OutputTemplate != "" {
+		if config.OutputFormat != "" {
+			return nil, fmt.Errorf("outputTemplate and outputFormat cannot be used together")
+		}
+		config.outputTemplateParsed, err = template.New("outputTemplate").
+			Option("missingkey=zero").
+			Funcs(template.FuncMap{"json": jsonString}).
+			Parse(config.OutputTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.BufferSize < 0 {
+		return nil, fmt.Errorf("bufferSize must be 0 or more but was %d", config.BufferSize)
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 100 * time.Millisecond
+	}
+	if config.FlushInterval < 0 {
+		return nil, fmt.Errorf("flushInterval must be more than 0 but was %v", config.FlushInterval)
+	}
+
+	if config.UnknownKeys != "" && config.UnknownKeys != "error" && config.UnknownKeys != "warn" {
+		return nil, fmt.Errorf("unknownKeys must be error or warn but was %v", config.UnknownKeys)
+	}
+
+	if config.MaxFieldLength < 0 {
+		return nil, fmt.Errorf("maxFieldLength must be 0 or more but was %d", config.MaxFieldLength)
+	}
+	if config.MaxEventBytes < 0 {
+		return nil, fmt.Errorf("maxEventBytes must be 0 or more but was %d", config.MaxEventBytes)
+	}
+
+	if config.Unmatched != "" && config.Unmatched != "wrap" && config.Unmatched != "passthrough" && config.Unmatched != "discard" {
+		return nil, fmt.Errorf("unmatched must be wrap, passthrough or discard but was %v", config.Unmatched)
+	}
+
+	if config.SinkFailure != "" && config.SinkFailure != "exit" && config.SinkFailure != "passthrough" {
+		return nil, fmt.Errorf("sinkFailure must be exit or passthrough but was %v", config.SinkFailure)
+	}
+
+	if config.MatchTimeout < 0 {
+		return nil, fmt.Errorf("matchTimeout must be 0 or more but was %v", config.MatchTimeout)
+	}
+
+	if config.Workers < 0 {
+		return nil, fmt.Errorf("workers must be 0 or more but was %d", config.Workers)
+	}
+
+	if config.Kubernetes != nil {
+		if err := config.Kubernetes.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Aggregate != nil {
+		if err := config.Aggregate.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Top != nil {
+		if err := config.Top.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Fingerprint != nil {
+		if err := config.Fingerprint.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Recent != nil {
+		if err := config.Recent.validate(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Dedup != nil {
+		if err := config.Dedup.validate(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := compileReplacements(config.Replace, config, "replace"); err != nil {
+		return nil, err
+	}
+
+	if config.Split != nil {
+		if err := config.Split.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Csv != nil {
+		if err := config.Csv.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range config.Lookups {
+		if err := config.Lookups[i].validate("lookups[" + strconv.Itoa(i) + "]"); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range config.Geoip {
+		if err := config.Geoip[i].validate("geoip[" + strconv.Itoa(i) + "]"); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range config.Redact {
+		config.Redact[i].regexParsed, err = compileRegex(config.Redact[i].Regex, "redact["+strconv.Itoa(i)+"].regex")
+		if err != nil {
+			return nil, err
+		}
+		if config.Redact[i].Replace == nil {
+			replace := "[REDACTED]"
+			config.Redact[i].Replace = &replace
+		}
+	}
+
+	if config.Hash != nil {
+		if err := config.Hash.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ExecFilter != nil {
+		if err := config.ExecFilter.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Wasm != nil {
+		if err := config.Wasm.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	config.lua, err = compileLua(config.Lua, "lua")
+	if err != nil {
+		return nil, err
+	}
+
+	config.timestampKeySet = (config.TimestampKey != "")
+	config.levelKeySet = (config.LevelKey != "")
+	if config.TimestampParse != nil {
+		if err := config.TimestampParse.validate(config); err != nil {
+			return nil, err
+		}
+	}
+	config.streamKeySet = (config.StreamKey != "")
+	for _, format := range config.Glog {
+		if _, found := headerFormats[format]; !found {
+			return nil, fmt.Errorf("glog must be one of %v but was %v", strings.Join(headerFormatNames, ", "), format)
+		}
+	}
+	config.glogSet = (len(config.Glog) != 0)
+	config.jsonSet = (config.Json != "")
+
+	// preprocess
+	for i := range config.Preprocess {
+		config.Preprocess[i].regexParsed, err = compileRegex(config.Preprocess[i].Regex, "preprocess["+strconv.Itoa(i)+"].regex")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Statsd != nil {
+		if config.Statsd.SampleRate == 0 {
+			config.Statsd.SampleRate = 1
+		}
+		if config.Statsd.SampleRate < 0 || config.Statsd.SampleRate > 1 {
+			return nil, fmt.Errorf("statsd.sampleRate must be between 0.0 - 1.0 but was %f", config.Statsd.SampleRate)
+		}
+		if config.Statsd.Address != "" && config.Statsd.Socket != "" {
+			return nil, fmt.Errorf("statsd.address and statsd.socket cannot be used together")
+		}
+	}
+
+	if config.Loki != nil {
+		if config.Loki.Url == "" {
+			return nil, fmt.Errorf("loki.url must be set")
+		}
+		if err := validateBatchWait("loki", config.Loki.BatchWait); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Elasticsearch != nil {
+		if config.Elasticsearch.Url == "" || config.Elasticsearch.Index == "" {
+			return nil, fmt.Errorf("elasticsearch.url and elasticsearch.index must be set")
+		}
+		if err := validateBatchWait("elasticsearch", config.Elasticsearch.BatchWait); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Kafka != nil {
+		if err := config.Kafka.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Splunk != nil {
+		if err := config.Splunk.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Otlp != nil {
+		if err := config.Otlp.validate(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.openBuffers(); err != nil {
+		return nil, err
+	}
+
+	retries := config.sinkRetries()
+	for _, name := range sortedMapKeys(retries) {
+		if err := retries[name].validate(name + ".retry"); err != nil {
+			return nil, err
+		}
+		retries[name].setDefaults(name)
+	}
+
+	if config.OutputFile != nil && config.OutputFile.Path == "" {
+		return nil, fmt.Errorf("outputFile.path must be set")
+	}
+
+	if config.Slack != nil {
+		if config.Slack.Url == "" {
+			return nil, fmt.Errorf("slack.url must be set")
+		}
+		if err := validateBatchWait("slack", config.Slack.BatchWait); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.validateRoutes(); err != nil {
+		return nil, err
+	}
+
+	config.alertsByPattern = map[string][]*Alert{}
+	for i := range config.Alerts {
+		alert := &config.Alerts[i]
+		if err := alert.validate("alerts["+strconv.Itoa(i)+"]", config.patternNames()); err != nil {
+			return nil, err
+		}
+		config.alertsByPattern[alert.Pattern] = append(config.alertsByPattern[alert.Pattern], alert)
+	}
+
+	config.rateAlertsByPattern = map[string][]*RateAlert{}
+	for i := range config.RateAlerts {
+		alert := &config.RateAlerts[i]
+		if err := alert.validate("rateAlerts["+strconv.Itoa(i)+"]", config.patternNames()); err != nil {
+			return nil, err
+		}
+		config.rateAlertsByPattern[alert.Pattern] = append(config.rateAlertsByPattern[alert.Pattern], alert)
+	}
+
+	metricNames := map[string]bool{}
+	for i := range config.Metrics {
+		location := "metrics[" + strconv.Itoa(i) + "]"
+		if err := config.Metrics[i].validate(location); err != nil {
+			return nil, err
+		}
+		if metricNames[config.Metrics[i].Name] {
+			return nil, fmt.Errorf("%v.name %v is used by another metric", location, config.Metrics[i].Name)
+		}
+		metricNames[config.Metrics[i].Name] = true
+	}
+	if len(config.Metrics) != 0 && config.Prometheus == nil && config.OtlpMetrics == nil {
+		return nil, fmt.Errorf("metrics requires prometheus or otlpMetrics to be configured")
+	}
+
+	if config.SelfMetrics {
+		if config.Prometheus == nil && config.Statsd == nil {
+			return nil, fmt.Errorf("selfMetrics requires prometheus or statsd to be configured")
+		}
+		config.telemetry = NewTelemetry()
+	}
+
+	if config.OtlpMetrics != nil {
+		if err := config.OtlpMetrics.validate(config.Metrics); err != nil {
+			return nil, err
+		}
+	}
+
+	// store all possible labels
+	if config.Prometheus != nil {
+		config.Prometheus.metrics = config.Metrics
+		if config.Prometheus.Metric == "" {
+			config.Prometheus.Metric = "logs_total"
+		}
+		if !metricNameRegex.MatchString(config.Prometheus.Metric) {
+			return nil, fmt.Errorf("prometheus.metric must match %v but was %v", metricNameRegex, config.Prometheus.Metric)
+		}
+		if strings.HasPrefix(config.Prometheus.Metric, "logrecycler_") || metricNames[config.Prometheus.Metric] {
+			return nil, fmt.Errorf("prometheus.metric %v is already used by another metric", config.Prometheus.Metric)
+		}
+		if err := config.Prometheus.validateServer(); err != nil {
+			return nil, err
+		}
+		if config.Prometheus.Pushgateway != nil {
+			if config.Prometheus.Pushgateway.Url == "" {
+				return nil, fmt.Errorf("prometheus.pushgateway.url must be set")
+			}
+			if config.Prometheus.Pushgateway.Job == "" {
+				config.Prometheus.Pushgateway.Job = "logrecycler"
+			}
+		}
+		if config.Prometheus.Help == "" {
+			config.Prometheus.Help = "Total number of logs received"
+		}
+		if config.VersionKey != "" {
+			if config.Prometheus.Labels == nil {
+				config.Prometheus.Labels = map[string]string{}
+			}
+			config.Prometheus.Labels[config.VersionKey] = Version
+		}
+		for _, label := range sortedMapKeys(config.Prometheus.Labels) {
+			if !model.LabelName(label).IsValid() || strings.HasPrefix(label, model.ReservedLabelPrefix) {
+				return nil, fmt.Errorf("prometheus.labels %v is not a valid label name", label)
+			}
+			if contains(reservedPrometheusLabels, label) {
+				return nil, fmt.Errorf("prometheus.labels %v is reserved, reserved are %v", label, strings.Join(reservedPrometheusLabels, ", "))
+			}
+			for i, metric := range config.Metrics {
+				if contains(prometheusLabelNames(metric.Labels), label) {
+					return nil, fmt.Errorf("prometheus.labels %v is also a label of metrics[%d]", label, i)
+				}
+			}
+		}
+		config.Prometheus.labelNames = config.possibleLabels()
+		for _, label := range config.Prometheus.labelNames {
+			if _, found := config.Prometheus.Labels[label]; found {
+				return nil, fmt.Errorf("prometheus.labels %v is also a dynamic label", label)
+			}
+		}
+		for label, max := range config.Prometheus.MaxCardinality {
+			if !contains(config.Prometheus.labelNames, label) {
+				return nil, fmt.Errorf("prometheus.maxCardinality %v is not a label, labels are %v", label, strings.Join(config.Prometheus.labelNames, ", "))
+			}
+			if max < 1 {
+				return nil, fmt.Errorf("prometheus.maxCardinality %v must be 1 or more but was %d", label, max)
+			}
+		}
+		config.Prometheus.patternNames = config.patternNames()
+		config.Prometheus.patternStats = config.patternStats
+		config.Prometheus.countUnmatched = config.Unmatched != "" || config.telemetry != nil
+		config.Prometheus.countOversized = config.MaxEventBytes != 0
+		config.Prometheus.buffers = config.buffers()
+		config.Prometheus.retries = retries
+		config.Prometheus.statsd = config.Statsd
+		config.Prometheus.telemetry = config.telemetry
+		config.Prometheus.top = config.Top
+		config.Prometheus.recent = config.Recent
+	}
+
+	return config, nil
+}
+
+// read a config file or merge all fragments in a directory (in alphabetical order)
+// later fragments override earlier settings, but inputs and patterns are combined
+// unknown keys are typos that silently do nothing, so they fail unless the file sets `unknownKeys: warn`
+func unmarshalConfig(content []byte, config *Config, path string) error {
+	err := yaml.UnmarshalStrict(content, config)
+	if err == nil {
+		return nil
+	}
+	var lenient Config
+	if yaml.Unmarshal(content, &lenient) != nil || lenient.UnknownKeys != "warn" {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Warning: %v: %v\n", path, err)
+	return yaml.Unmarshal(content, config)
+}
+
+func readConfig(path string, overrides []string) (*Config, error) {
+	var config Config
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err // untested section
+		}
+		content = expandEnv(content)
+		if err = unmarshalConfig(content, &config, path); err != nil {
+			return nil, err
+		}
+		return &config, applyOverrides(&config, overrides)
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.y*ml"))
+	if err != nil {
+		return nil, err // untested section
+	}
+	sort.Strings(files)
+
+	inputs := []Input{}
+	patterns := []Pattern{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err // untested section
+		}
+		content = expandEnv(content)
+
+		var fragment Config
+		if err = unmarshalConfig(content, &fragment, file); err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		inputs = append(inputs, fragment.Inputs...)
+		patterns = append(patterns, fragment.Patterns...)
+
+		if err = yaml.Unmarshal(content, &config); err != nil {
+			return nil, err // untested section
+		}
+	}
+	config.Inputs = inputs
+	config.Patterns = patterns
+
+	return &config, applyOverrides(&config, overrides)
+}
+
+// apply `-set key=value` overrides, nested keys are separated by dots and values are parsed as yaml
+func applyOverrides(config *Config, overrides []string) error {
+	for _, override := range overrides {
+		key, value, _ := strings.Cut(override, "=")
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			return fmt.Errorf("-set %v: %v", override, err)
+		}
+		keys := strings.Split(key, ".")
+		for i := len(keys) - 1; i >= 0; i-- {
+			parsed = map[string]interface{}{keys[i]: parsed}
+		}
+		content, err := yaml.Marshal(parsed)
+		if err != nil {
+			return fmt.Errorf("-set %v: %v", override, err) // untested section
+		}
+		if err := yaml.UnmarshalStrict(content, config); err != nil {
+			return fmt.Errorf("-set %v: %v", override, err)
+		}
+	}
+	return nil
+}
+
+// compile an expression that is evaluated against the fields of a log, see https://expr-lang.org
+func compileCondition(condition string, location string) (*vm.Program, error) {
+	program, err := expr.Compile(condition, expr.Env(map[string]string{}), expr.AllowUndefinedVariables(), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", location, err)
+	}
+	return program, nil
+}
+
+// rank of a configured level, 0 when not configured
+func parseLevelRank(level string, config *Config, location string) (int, error) {
+	if level == "" {
+		return 0, nil
+	}
+	if config.LevelKey == "" {
+		return 0, fmt.Errorf("%v requires levelKey to be set", location)
+	}
+	rank, found := levelRanks[strings.ToUpper(level)]
+	if !found {
+		return 0, fmt.Errorf("%v must be one of TRACE, DEBUG, INFO, WARN, ERROR, FATAL but was %v", location, level)
+	}
+	return rank, nil
+}
+
+// replace ${VAR} and ${VAR:-default} with environment variables
+// unset variables without default are kept so they can be used by regex replacements, $${VAR} is not replaced
+func expandEnv(content []byte) []byte {
+	return envRegex.ReplaceAllFunc(content, func(match []byte) []byte {
+		if match[1] == '$' {
+			return match[1:]
+		}
+		parts := envRegex.FindSubmatch(match)
+		if value, found := os.LookupEnv(string(parts[1])); found {
+			return []byte(value)
+		}
+		if parts[2] != nil {
+			return parts[3]
+		}
+		return match
+	})
+}
+
+// names of patterns that are reported, unnamed patterns are not
+func (c *Config) patternNames() []string {
+	names := []string{}
+	for _, pattern := range c.Patterns {
+		if pattern.Name != "" {
+			names = append(names, pattern.Name)
+		}
+	}
+	return unique(names)
+}
+
+func compileReplacements(replacements []Replacement, config *Config, location string) error {
+	for i := range replacements {
+		var err error
+		replacements[i].regexParsed, err = compileRegex(replacements[i].Regex, location+"["+strconv.Itoa(i)+"].regex")
+		if err != nil {
+			return err
+		}
+		if len(replacements[i].Fields) == 0 {
+			replacements[i].Fields = []string{config.MessageKey}
+		}
+	}
+	return nil
+}
+
+// labels after applying rename and remove
+func renameAndRemove(labels []string, rename map[string]string, remove []string) []string {
+	renamed := make([]string, len(labels))
+	for i, label := range labels {
+		if to, found := rename[label]; found {
+			label = to
+		}
+		renamed[i] = label
+	}
+	for _, label := range remove {
+		renamed = removeElement(renamed, label)
+	}
+	return renamed
+}
+
+// output name for each submatch of the regex, applying captures and captureMap
+func (p *Pattern) compileCaptures(location string) error {
+	names := p.regexParsed.SubexpNames()
+	for _, name := range p.Captures {
+		if name == "" || !contains(names, name) {
+			return fmt.Errorf("%v.captures %v is not a capture of the regex", location, name)
+		}
+	}
+	for name := range p.CaptureMap {
+		if name == "" || !contains(names, name) {
+			return fmt.Errorf("%v.captureMap %v is not a capture of the regex", location, name)
+		}
+	}
+
+	p.captureNames = make([]string, len(names))
+	for i, name := range names {
+		if name == "" || (p.Captures != nil && !contains(p.Captures, name)) {
+			continue
+		}
+		if to, found := p.CaptureMap[name]; found {
+			name = to
+		}
+		p.captureNames[i] = name
+	}
+	return nil
+}
+
+// inline flags for ignoreCase and dotall
+func (p *Pattern) flags() string {
+	flags := ""
+	if p.IgnoreCase {
+		flags += "i"
+	}
+	if p.Dotall {
+		flags += "s"
+	}
+	if flags == "" {
+		return ""
+	}
+	return "(?" + flags + ")"
+}
+
+// regexes that also need to match or not match, captures only come from regex
+func (p *Pattern) compileConditions(location string) error {
+	var err error
+	if p.RegexNot != "" {
+		if p.regexNotParsed, err = compileRegex(p.flags()+p.RegexNot, location+".regexNot"); err != nil {
+			return err
+		}
+	}
+	for i, regex := range p.AllOf {
+		parsed, err := compileRegex(p.flags()+regex, location+".allOf["+strconv.Itoa(i)+"]")
+		if err != nil {
+			return err
+		}
+		p.allOfParsed = append(p.allOfParsed, parsed)
+	}
+	for i, regex := range p.AnyOf {
+		parsed, err := compileRegex(p.flags()+regex, location+".anyOf["+strconv.Itoa(i)+"]")
+		if err != nil {
+			return err
+		}
+		p.anyOfParsed = append(p.anyOfParsed, parsed)
+	}
+	return nil
+}
+
+// names of the captures that end up in the log
+func (p *Pattern) outputCaptures() []string {
+	names := []string{}
+	for _, name := range p.captureNames {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// disk buffers of sinks by sink name
+func (c *Config) buffers() map[string]*DiskBuffer {
+	buffers := map[string]*DiskBuffer{}
+	if c.Loki != nil && c.Loki.Buffer != nil {
+		buffers["loki"] = c.Loki.Buffer
+	}
+	if c.Elasticsearch != nil && c.Elasticsearch.Buffer != nil {
+		buffers["elasticsearch"] = c.Elasticsearch.Buffer
+	}
+	if c.Kafka != nil && c.Kafka.Buffer != nil {
+		buffers["kafka"] = c.Kafka.Buffer
+	}
+	if c.Splunk != nil && c.Splunk.Buffer != nil {
+		buffers["splunk"] = c.Splunk.Buffer
+	}
+	return buffers
+}
+
+// retry settings of network sinks by sink name, sinks without settings get the defaults
+func (c *Config) sinkRetries() map[string]*Retry {
+	retries := map[string]*Retry{}
+	buffers := c.buffers()
+	add := func(name string, retry **Retry) {
+		if *retry == nil {
+			*retry = &Retry{}
+		}
+		(*retry).buffered = buffers[name] != nil
+		retries[name] = *retry
+	}
+	if c.Loki != nil {
+		add("loki", &c.Loki.Retry)
+	}
+	if c.Elasticsearch != nil {
+		add("elasticsearch", &c.Elasticsearch.Retry)
+		if c.Elasticsearch.Retry.Attempts == nil {
+			c.Elasticsearch.Retry.Attempts = c.Elasticsearch.Retries
+		}
+	}
+	if c.Kafka != nil {
+		add("kafka", &c.Kafka.Retry)
+	}
+	if c.Splunk != nil {
+		add("splunk", &c.Splunk.Retry)
+		if c.Splunk.Retry.Attempts == nil {
+			c.Splunk.Retry.Attempts = c.Splunk.Retries
+		}
+	}
+	if c.Otlp != nil {
+		add("otlp", &c.Otlp.Retry)
+	}
+	if c.Slack != nil {
+		add("slack", &c.Slack.Retry)
+	}
+	return retries
+}
+
+func (c *Config) openBuffers() error {
+	buffers := c.buffers()
+	paths := map[string]string{}
+	for _, name := range sortedMapKeys(buffers) {
+		if other, found := paths[buffers[name].Path]; found && buffers[name].Path != "" {
+			return fmt.Errorf("%v.buffer.path must not be the same as %v.buffer.path", name, other)
+		}
+		paths[buffers[name].Path] = name
+		if err := buffers[name].open(name + ".buffer"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys of values with ${field} references that need to be expanded per log
+func templatedKeys(add map[string]string) []string {
+	var templated []string
+	for _, key := range sortedMapKeys(add) {
+		if fieldReferenceRegex.MatchString(add[key]) {
+			templated = append(templated, key)
+		}
+	}
+	return templated
+}
+
+// all labels that could ever be used by the given config
+func (c *Config) possibleLabels() []string {
+	labels := []string{}
+
+	if c.levelKeySet {
+		labels = append(labels, c.LevelKey)
+	}
+
+	for _, step := range c.Preprocess {
+		if step.Replace == nil {
+			addCaptureNames(step.regexParsed, &labels)
+		}
+	}
+
+	// all possible captures and `add`
+	for _, pattern := range c.Patterns {
+		if pattern.Discard {
+			continue
+		}
+
+		patternLabels := []string{}
+		patternLabels = append(patternLabels, pattern.outputCaptures()...)
+		patternLabels = append(patternLabels, pattern.Kv.Keys...)
+
+		if pattern.Add != nil {
+			patternLabels = append(patternLabels, keys(pattern.Add)...)
+		}
+
+		patternLabels = renameAndRemove(patternLabels, pattern.Rename, pattern.Remove)
+
+		for _, l := range pattern.IgnoreMetricLabels {
+			patternLabels = removeElement(patternLabels, l)
+		}
+
+		labels = append(labels, patternLabels...)
+	}
+
+	labels = append(labels, c.Kv.Keys...)
+	if c.Csv != nil {
+		for _, column := range c.Csv.Columns {
+			if column != "" {
+				labels = append(labels, column)
+			}
+		}
+	}
+
+	for _, lookup := range c.Lookups {
+		labels = append(labels, lookup.To)
+	}
+
+	for i := range c.Geoip {
+		labels = append(labels, c.Geoip[i].keys()...)
+	}
+
+	if c.Kubernetes != nil && c.Kubernetes.MetricLabels {
+		labels = append(labels, c.Kubernetes.keys...)
+	}
+
+	if c.Fingerprint != nil && c.Fingerprint.MetricLabel {
+		labels = append(labels, c.Fingerprint.Key)
+	}
+
+	labels = renameAndRemove(labels, c.Rename, c.Remove)
+	labels = unique(labels)
+	labels = removeElement(labels, c.MessageKey) // would make stats useless
+
+	return labels
+}
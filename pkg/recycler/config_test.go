@@ -0,0 +1,553 @@
+package recycler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("config", func() {
+	Describe("NewConfig", func() {
+		It("can parse empty file", func() {
+			withConfig("", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err).To(BeNil())
+			})
+		})
+
+		It("fails when file is not found", func() {
+			_, err := NewConfig("whoops")
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("fails on unknown flags", func() {
+			withConfig("wut: true", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err).ToNot(BeNil())
+			})
+		})
+
+		It("merges fragments from a directory", func() {
+			withConfigDir(map[string]string{
+				"a.yaml": "levelKey: level\npatterns:\n- regex: a",
+				"b.yml":  "levelKey: lvl\npatterns:\n- regex: b",
+				"c.txt":  "wut: true",
+			}, func(dir string) {
+				config, err := NewConfig(dir)
+				Expect(err).To(BeNil())
+				Expect(config.LevelKey).To(Equal("lvl"))
+				Expect(len(config.Patterns)).To(Equal(2))
+				Expect(config.Patterns[0].Regex).To(Equal("a"))
+				Expect(config.Patterns[1].Regex).To(Equal("b"))
+			})
+		})
+
+		It("shows which fragment failed", func() {
+			withConfigDir(map[string]string{"a.yaml": "wut: true"}, func(dir string) {
+				_, err := NewConfig(dir)
+				Expect(err.Error()).To(ContainSubstring("a.yaml: yaml: unmarshal errors"))
+			})
+		})
+
+		It("expands environment variables", func() {
+			Expect(os.Setenv("LOGRECYCLER_TEST", "foo")).To(BeNil())
+			defer os.Unsetenv("LOGRECYCLER_TEST")
+			withConfig("levelKey: ${LOGRECYCLER_TEST}\nmessageKey: ${LOGRECYCLER_NOPE:-msg}\npatterns:\n- regex: ${LOGRECYCLER_NOPE}$${LOGRECYCLER_TEST}", func() {
+				config, err := NewConfig("logrecycler.yaml")
+				Expect(err).To(BeNil())
+				Expect(config.LevelKey).To(Equal("foo"))
+				Expect(config.MessageKey).To(Equal("msg"))
+				Expect(config.Patterns[0].Regex).To(Equal("${LOGRECYCLER_NOPE}${LOGRECYCLER_TEST}"))
+			})
+		})
+
+		It("fails on invalid prometheus metric", func() {
+			withConfig("prometheus:\n  metric: foo-bar", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.metric must match ^[a-zA-Z_:][a-zA-Z0-9_:]*$ but was foo-bar"))
+			})
+		})
+
+		It("fails on missing prometheus tls files", func() {
+			withConfig("prometheus:\n  tls:\n    certFile: /nope.crt\n    keyFile: /nope.key", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.tls: open /nope.crt: no such file or directory"))
+			})
+		})
+
+		It("fails on static prometheus labels that are also dynamic", func() {
+			withConfig("levelKey: level\nprometheus:\n  labels:\n    level: x", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.labels level is also a dynamic label"))
+			})
+		})
+
+		It("fails on invalid static prometheus labels", func() {
+			withConfig("prometheus:\n  labels:\n    my-team: x", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.labels my-team is not a valid label name"))
+			})
+		})
+
+		It("fails on static prometheus labels that logrecycler metrics use", func() {
+			withConfig("prometheus:\n  labels:\n    sink: x", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.labels sink is reserved, reserved are label, le, pattern, quantile, sink"))
+			})
+		})
+
+		It("fails on static prometheus labels that are also metric labels", func() {
+			withConfig("prometheus:\n  labels:\n    status: x\nmetrics:\n- name: foo\n  field: bar\n  labels: [status]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.labels status is also a label of metrics[0]"))
+			})
+		})
+
+		It("fails on negative flushInterval", func() {
+			withConfig("bufferSize: 10\nflushInterval: -1s", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("flushInterval must be more than 0 but was -1s"))
+			})
+		})
+
+		It("fails on negative batchWait", func() {
+			for _, sink := range []string{
+				"loki:\n  url: http://loki",
+				"elasticsearch:\n  url: http://es\n  index: logs",
+				"kafka:\n  brokers: [kafka:9092]\n  topic: logs",
+				"splunk:\n  url: http://splunk\n  token: secret",
+				"otlp:\n  endpoint: otlp:4317",
+				"slack:\n  url: http://slack",
+			} {
+				withConfig(sink+"\n  batchWait: -1s", func() {
+					_, err := NewConfig("logrecycler.yaml")
+					Expect(err.Error()).To(Equal(strings.Split(sink, ":")[0] + ".batchWait must be more than 0 but was -1s"))
+				})
+			}
+		})
+
+		It("fails on unknown minLevel", func() {
+			withConfig("levelKey: level\nminLevel: LOUD", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("minLevel must be one of TRACE, DEBUG, INFO, WARN, ERROR, FATAL but was LOUD"))
+			})
+		})
+
+		It("fails on discardBelow without levelKey", func() {
+			withConfig("patterns:\n- regex: hi\n  discardBelow: WARN", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].discardBelow requires levelKey to be set"))
+			})
+		})
+
+		It("fails on unknown glog format", func() {
+			withConfig("glog: [glog, nope]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("glog must be one of glog, klog, zap, logrus-text but was nope"))
+			})
+		})
+
+		It("fails on maxCardinality for unknown labels", func() {
+			withConfig("levelKey: level\nprometheus:\n  maxCardinality:\n    foo: 1", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.maxCardinality foo is not a label, labels are level"))
+			})
+		})
+
+		It("fails on invalid statsd sample rate", func() {
+			withConfig("statsd:\n  sampleRate: 2", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("statsd.sampleRate must be between 0.0 - 1.0 but was 2.000000"))
+			})
+		})
+
+		It("fails on inputs without path, listen or journald", func() {
+			withConfig("inputs:\n- follow: true", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("inputs[0] must set one of path, listen or journald"))
+			})
+		})
+
+		It("fails on unknown journald priority", func() {
+			withConfig("inputs:\n- journald: true\n  priority: loud", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("inputs[0].priority must be one of emerg, alert, crit, err, warning, notice, info, debug but was loud"))
+			})
+		})
+
+		It("fails on invalid rate limit", func() {
+			withConfig("patterns:\n- regex: hi\n  rateLimit:\n    count: 1", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].rateLimit.per must be set"))
+			})
+		})
+
+		It("fails on unknown keys with their location", func() {
+			withConfig("patterns:\n- regex: hi\n  dicard: true", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("yaml: unmarshal errors:\n  line 3: field dicard not found in type recycler.Pattern"))
+			})
+		})
+
+		It("can only warn on unknown keys", func() {
+			withConfig("unknownKeys: warn\npatterns:\n- regex: hi\n  dicard: true", func() {
+				var config *Config
+				var err error
+				Expect(captureStderr(func() { config, err = NewConfig("logrecycler.yaml") })).To(Equal(
+					"Warning: logrecycler.yaml: yaml: unmarshal errors:\n  line 4: field dicard not found in type recycler.Pattern\n"))
+				Expect(err).To(BeNil())
+				Expect(config.Patterns[0].Regex).To(Equal("hi"))
+			})
+		})
+
+		It("fails on unknown captures", func() {
+			withConfig("patterns:\n- regex: (?P<a>.)\n  captures: [b]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].captures b is not a capture of the regex"))
+			})
+		})
+
+		It("fails on invalid lookup files", func() {
+			withFile("a,b,c\n", func(path string) {
+				withConfig("lookups:\n- field: a\n  to: b\n  file: "+path, func() {
+					_, err := NewConfig("logrecycler.yaml")
+					Expect(err.Error()).To(Equal("lookups[0].file: record on line 1: wrong number of fields"))
+				})
+			})
+		})
+
+		It("fails on missing geoip database", func() {
+			withConfig("geoip:\n- field: ip\n  city: /nope.mmdb", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("geoip[0].city: open /nope.mmdb: no such file or directory"))
+			})
+		})
+
+		It("fails on unknown access log format", func() {
+			withConfig("patterns:\n- format: iis", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].format must be one of apache, nginx, envoy but was iis"))
+			})
+		})
+
+		It("fails on invalid csv delimiter", func() {
+			withConfig("csv:\n  delimiter: ab\n  columns: [a]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("csv.delimiter must be a single character other than \" but was ab"))
+			})
+		})
+
+		It("fails on missing kubernetes labels file", func() {
+			withConfig("kubernetes:\n  labels: [app]\n  labelsFile: /nope", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("kubernetes.labelsFile: open /nope: no such file or directory"))
+			})
+		})
+
+		It("fails on per pattern statsd timing without field", func() {
+			withConfig("statsd:\n  metric: foo\npatterns:\n- regex: hi\n  statsd:\n    type: timing", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].statsd.field must be set for timing"))
+			})
+		})
+
+		It("fails on unknown statsd event alert type", func() {
+			withConfig("statsd:\n  metric: foo\npatterns:\n- regex: hi\n  statsdEvent:\n    alertType: bad", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].statsdEvent.alertType must be info, warning, error or success but was bad"))
+			})
+		})
+
+		It("fails on unknown kafka compression", func() {
+			withConfig("kafka:\n  brokers: [localhost:9092]\n  topic: logs\n  compression: nope", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("kafka.compression must be gzip, snappy, lz4 or zstd but was nope"))
+			})
+		})
+
+		It("fails on timestampParse without timestampKey", func() {
+			withConfig("timestampParse:\n  regex: (.*)\n  layouts: [unix]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("timestampParse requires timestampKey to be set"))
+			})
+		})
+
+		It("fails on selfMetrics without metrics", func() {
+			withConfig("selfMetrics: true", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("selfMetrics requires prometheus or statsd to be configured"))
+			})
+		})
+
+		It("fails on negative retry attempts", func() {
+			withConfig("loki:\n  url: http://loki\n  retry:\n    attempts: -1", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("loki.retry.attempts must be 0 or more but was -1"))
+			})
+		})
+
+		It("fails on buffers without path", func() {
+			withConfig("loki:\n  url: http://loki\n  buffer:\n    maxBytes: 100", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("loki.buffer.path must be set"))
+			})
+		})
+
+		It("fails on hmac without salt", func() {
+			withConfig("hash:\n  fields: [user]\n  algorithm: hmac", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("hash.saltEnv must be set for hmac"))
+			})
+		})
+
+		It("fails on hash salt that is not set", func() {
+			withConfig("hash:\n  fields: [user]\n  saltEnv: TEST_HASH_SALT_NOPE", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("hash.saltEnv TEST_HASH_SALT_NOPE is not set"))
+			})
+		})
+
+		It("fails on execFilter without command", func() {
+			withConfig("execFilter:\n  framing: lines", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("execFilter.command must be set"))
+			})
+		})
+
+		It("fails on negative execFilter batchSize", func() {
+			withConfig("execFilter:\n  command: [cat]\n  batchSize: -1", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("execFilter.batchSize must be 0 or more but was -1"))
+			})
+		})
+
+		It("fails on unknown execFilter framing", func() {
+			withConfig("execFilter:\n  command: [cat]\n  framing: nope", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("execFilter.framing must be lines or length but was nope"))
+			})
+		})
+
+		It("fails on missing wasm module", func() {
+			withConfig("wasm:\n  module: nope.wasm", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("wasm.module: open nope.wasm: no such file or directory"))
+			})
+		})
+
+		It("fails on wasm module without filter", func() {
+			withFile("\x00asm\x01\x00\x00\x00", func(path string) {
+				withConfig("wasm:\n  module: "+path, func() {
+					_, err := NewConfig("logrecycler.yaml")
+					Expect(err.Error()).To(Equal("wasm.module must export memory, allocate and filter"))
+				})
+			})
+		})
+
+		It("fails on invalid lua", func() {
+			withConfig("patterns:\n- regex: a\n  lua: 'log.a = '", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("patterns[0].lua at EOF:   syntax error"))
+			})
+		})
+
+		It("fails on aggregate without window", func() {
+			withConfig("aggregate:\n  by: [status]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("aggregate.window must be set"))
+			})
+		})
+
+		It("fails on aggregate without fields", func() {
+			withConfig("aggregate:\n  window: 1m", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("aggregate.by must be set"))
+			})
+		})
+
+		It("fails on top count above capacity", func() {
+			withConfig("top:\n  count: 20\n  capacity: 10", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("top.count must be between 1 and top.capacity (10) but was 20"))
+			})
+		})
+
+		It("fails on fingerprint similarity above 1", func() {
+			withConfig("fingerprint:\n  similarity: 2", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("fingerprint.similarity must be between 0.0 - 1.0 but was 2"))
+			})
+		})
+
+		It("fails on recent without prometheus", func() {
+			withConfig("recent: {}", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("recent requires prometheus to be configured"))
+			})
+		})
+
+		It("fails on extraKey without outputFields", func() {
+			withConfig("extraKey: extra", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("extraKey requires outputFields to be set"))
+			})
+		})
+
+		It("fails on stderrLevels without levelKey", func() {
+			withConfig("stderrLevels: [ERROR]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("stderrLevels requires levelKey to be set"))
+			})
+		})
+
+		It("fails on levelKeywords without levelKey", func() {
+			withConfig("levelKeywords: true", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("levelKeywords requires levelKey to be set"))
+			})
+		})
+
+		It("fails on routes to outputs that are not configured", func() {
+			withConfig("routes:\n- outputs: [loki]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("routes[0].outputs loki is not configured"))
+			})
+		})
+
+		It("fails on rateAlerts with a negative interval", func() {
+			withConfig("rateAlerts:\n- pattern: crash\n  interval: -1s\npatterns:\n- name: crash\n  regex: crash", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("rateAlerts[0].interval must be more than 0 but was -1s"))
+			})
+		})
+
+		It("fails on rateAlerts with a factor that cannot deviate", func() {
+			withConfig("rateAlerts:\n- pattern: crash\n  factor: 0.5\npatterns:\n- name: crash\n  regex: crash", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("rateAlerts[0].factor must be above 1 but was 0.5"))
+			})
+		})
+
+		It("fails on alerts for unknown patterns", func() {
+			withConfig("alerts:\n- pattern: nope", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("alerts[0].pattern must be the name of a pattern but was nope"))
+			})
+		})
+
+		It("fails on metrics without prometheus", func() {
+			withConfig("metrics:\n- name: foo\n  field: bar", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics requires prometheus or otlpMetrics to be configured"))
+			})
+		})
+
+		It("fails on invalid metric type", func() {
+			withConfig("metrics:\n- name: foo\n  field: bar\n  type: gauge", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics[0].type must be histogram or summary but was gauge"))
+			})
+		})
+
+		It("fails on metrics named like logrecycler metrics", func() {
+			withConfig("prometheus: {}\nmetrics:\n- name: logrecycler_unmatched_total\n  field: bar", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics[0].name must not start with logrecycler_ since those metrics are reported by logrecycler but was logrecycler_unmatched_total"))
+			})
+		})
+
+		It("fails on metrics named like the prometheus metric", func() {
+			withConfig("prometheus: {}\nmetrics:\n- name: logs_total\n  field: bar", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("prometheus.metric logs_total is already used by another metric"))
+			})
+		})
+
+		It("fails on duplicate metric names", func() {
+			withConfig("prometheus: {}\nmetrics:\n- name: foo\n  field: bar\n- name: foo\n  field: baz", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics[1].name foo is used by another metric"))
+			})
+		})
+
+		It("fails on unsorted metric buckets", func() {
+			withConfig("prometheus: {}\nmetrics:\n- name: foo\n  field: bar\n  buckets: [1, 0.5]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics[0].buckets must be in increasing order but were [1 0.5]"))
+			})
+		})
+
+		It("fails on metric labels used by prometheus", func() {
+			withConfig("prometheus: {}\nmetrics:\n- name: foo\n  field: bar\n  labels: [le]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics[0].labels must not contain le since it is used by the histogram"))
+			})
+			withConfig("prometheus: {}\nmetrics:\n- name: foo\n  field: bar\n  type: summary\n  labels: [quantile]", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("metrics[0].labels must not contain quantile since it is used by the summary"))
+			})
+		})
+
+		It("fails on invalid outputTemplate", func() {
+			withConfig("outputTemplate: '{{.foo'", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(ContainSubstring("template: outputTemplate:1: unclosed action"))
+			})
+		})
+
+		It("fails on invalid when", func() {
+			withConfig("patterns:\n- regex: hi\n  when: 'a =='", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(HavePrefix("patterns[0].when: unexpected token EOF"))
+			})
+		})
+
+		It("fails on when that is not a condition", func() {
+			withConfig("patterns:\n- regex: hi\n  when: 'a'", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(HavePrefix("patterns[0].when: expected bool, but got string"))
+			})
+		})
+
+		It("fails on invalid outputFormat", func() {
+			withConfig("outputFormat: xml", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("outputFormat must be json or logfmt but was xml"))
+			})
+		})
+
+		It("fails on invalid workers", func() {
+			withConfig("workers: -1", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("workers must be 0 or more but was -1"))
+			})
+		})
+
+		It("fails on invalid sample rate", func() {
+			for _, sampleRate := range []float32{-0.1, 1.1} {
+				config := fmt.Sprintf("---\npatterns:\n- regex: hi\n  sampleRate: %f", sampleRate)
+				withConfig(config, func() {
+					_, err := NewConfig("logrecycler.yaml")
+					Expect(err).ToNot(BeNil())
+					expected := fmt.Sprintf("sample must be between 0.0 - 1.0 but was %f", sampleRate)
+					Expect(err.Error()).Should(Equal(expected))
+				})
+			}
+		})
+	})
+})
+
+func withConfigDir(files map[string]string, fn func(dir string)) {
+	dir, err := ioutil.TempDir("", "logrecycler")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	for name, content := range files {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(BeNil())
+	}
+	fn(dir)
+}
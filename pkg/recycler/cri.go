@@ -0,0 +1,33 @@
+package recycler
+
+import (
+	"regexp"
+	"time"
+)
+
+// 2024-01-01T00:00:00.000000000Z stdout F message (F is a full line, P a partial one)
+// https://github.com/kubernetes/design-proposals-archive/blob/main/node/kubelet-cri-logging.md
+var criRegex = regexp.MustCompile(`^(\d{4}-\d\d-\d\dT\S+) (stdout|stderr) ([FP])(?::\S+)? ?`)
+
+// strip the containerd/cri-o prefix of files in /var/log/containers and capture timestamp and stream
+func captureCri(config *Config, log *OrderedMap) {
+	message := log.values[config.MessageKey]
+	match := criRegex.FindStringSubmatch(message)
+	if match == nil {
+		return
+	}
+	log.values[config.MessageKey] = message[len(match[0]):]
+	if config.timestampKeySet {
+		if parsed, err := time.Parse(time.RFC3339Nano, match[1]); err == nil {
+			log.values[config.TimestampKey] = parsed.Format(timeFormat)
+		}
+	}
+	if config.streamKeySet {
+		log.Set(config.StreamKey, match[2])
+	} else {
+		log.Set("stream", match[2])
+	}
+	if match[3] == "P" {
+		log.Set("partial", "true")
+	}
+}
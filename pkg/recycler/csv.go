@@ -0,0 +1,46 @@
+package recycler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Csv splits the message on a delimiter and maps the columns to fields, for example exported audit logs
+type Csv struct {
+	Delimiter string   // (default ,)
+	Columns   []string // field per column, empty to skip the column
+	delimiter rune
+}
+
+func (c *Csv) validate() error {
+	if len(c.Columns) == 0 {
+		return fmt.Errorf("csv.columns must be set")
+	}
+	if c.Delimiter == "" {
+		c.Delimiter = ","
+	}
+	if utf8.RuneCountInString(c.Delimiter) != 1 || c.Delimiter == `"` {
+		return fmt.Errorf("csv.delimiter must be a single character other than \" but was %v", c.Delimiter)
+	}
+	c.delimiter, _ = utf8.DecodeRuneInString(c.Delimiter)
+	return nil
+}
+
+// Capture the columns of the message, lines that are not valid csv are left alone
+func (c *Csv) Capture(log *OrderedMap, message string) {
+	reader := csv.NewReader(strings.NewReader(message))
+	reader.Comma = c.delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	values, err := reader.Read()
+	if err != nil {
+		return
+	}
+	for i, value := range values {
+		if i < len(c.Columns) && c.Columns[i] != "" {
+			log.Set(c.Columns[i], value)
+		}
+	}
+}
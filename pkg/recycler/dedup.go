@@ -0,0 +1,146 @@
+package recycler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dedup suppresses logs with the same fields within a window, the next log after the window reports the repeat_count,
+// or a summary with the fields and repeat_count is output when the window ends without one
+type Dedup struct {
+	Fields    []string // default message
+	Window    time.Duration
+	lock      sync.Mutex
+	seen      map[string]*dedupEntry
+	lastSweep time.Time
+	stop      chan struct{}
+	done      sync.WaitGroup
+}
+
+type dedupEntry struct {
+	first      time.Time
+	values     []string // of fields, for the summary
+	suppressed int
+}
+
+func (d *Dedup) validate(config *Config) error {
+	if d.Window <= 0 {
+		return fmt.Errorf("dedup.window must be set")
+	}
+	if len(d.Fields) == 0 {
+		d.Fields = []string{config.MessageKey}
+	}
+	d.seen = map[string]*dedupEntry{}
+	return nil
+}
+
+// Allow returns false for duplicates and sets repeat_count on the first log after duplicates were suppressed
+func (d *Dedup) Allow(log *OrderedMap, now time.Time) bool {
+	fingerprint := fieldsFingerprint(log, d.Fields)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.sweep(now)
+
+	entry, found := d.seen[fingerprint]
+	if found && now.Sub(entry.first) < d.Window {
+		entry.suppressed++
+		return false
+	}
+	if found && entry.suppressed != 0 {
+		log.Set("repeat_count", strconv.Itoa(entry.suppressed))
+	}
+	values := make([]string, len(d.Fields))
+	for i, field := range d.Fields {
+		values[i] = log.values[field]
+	}
+	d.seen[fingerprint] = &dedupEntry{first: now, values: values}
+	return true
+}
+
+// Start outputting summaries of duplicates whose window ended, so they do not pile up waiting for the next log
+func (d *Dedup) Start(config *Config, output func(*OrderedMap)) {
+	d.stop = make(chan struct{})
+	d.done.Add(1)
+	go func() {
+		defer d.done.Done()
+		ticker := time.NewTicker(d.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				d.flush(config, output, func(entry *dedupEntry) bool { return now.Sub(entry.first) >= d.Window })
+			case <-d.stop:
+				d.flush(config, output, func(*dedupEntry) bool { return true })
+				return
+			}
+		}
+	}()
+}
+
+// Stop outputs the summaries of all suppressed duplicates
+func (d *Dedup) Stop() {
+	close(d.stop)
+	d.done.Wait()
+}
+
+// output and forget the entries with suppressed duplicates that are done
+func (d *Dedup) flush(config *Config, output func(*OrderedMap), done func(*dedupEntry) bool) {
+	var entries []*dedupEntry
+	d.lock.Lock()
+	for fingerprint, entry := range d.seen {
+		if entry.suppressed != 0 && done(entry) {
+			entries = append(entries, entry)
+			delete(d.seen, fingerprint)
+		}
+	}
+	d.lock.Unlock()
+
+	// in the order the duplicates were first seen
+	sort.Slice(entries, func(i, j int) bool { return entries[i].first.Before(entries[j].first) })
+	now := time.Now()
+	for _, entry := range entries {
+		summary := acquireOrderedMap()
+		if config.timestampKeySet {
+			summary.Set(config.TimestampKey, now.Format(timeFormat))
+		}
+		if config.levelKeySet {
+			summary.Set(config.LevelKey, "INFO")
+		}
+		for i, field := range d.Fields {
+			summary.Set(field, entry.values[i])
+		}
+		summary.Set("repeat_count", strconv.Itoa(entry.suppressed))
+		output(summary)
+	}
+}
+
+// values of all fields, with length prefix so different splits do not collide
+func fieldsFingerprint(log *OrderedMap, fields []string) string {
+	var fingerprint strings.Builder
+	for _, field := range fields {
+		value := log.values[field]
+		fingerprint.WriteString(strconv.Itoa(len(value)) + ":" + value)
+	}
+	return fingerprint.String()
+}
+
+// forget expired entries that were not repeated, so memory does not grow forever,
+// when summaries are not output (Processor) repeats are forgotten after another window
+func (d *Dedup) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.Window {
+		return
+	}
+	d.lastSweep = now
+	for fingerprint, entry := range d.seen {
+		age := now.Sub(entry.first)
+		if entry.suppressed == 0 && age >= d.Window || d.stop == nil && age >= 2*d.Window {
+			delete(d.seen, fingerprint)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package recycler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// DiskBuffer stores logs a sink could not send or keep up with in a file, one json per line,
+// so outages do not lose logs or block reading, they are sent once the sink recovers
+type DiskBuffer struct {
+	Path     string // file to store logs in, kept across restarts
+	MaxBytes int64  `yaml:"maxBytes"` // drop new logs when the file is this big (default 100MB)
+	lock     sync.Mutex
+	file     *os.File
+	size     int64
+	queued   int64 // logs in the file, read by metrics
+	dropped  uint64
+}
+
+func (d *DiskBuffer) open(location string) error {
+	if d.Path == "" {
+		return fmt.Errorf("%v.path must be set", location)
+	}
+	if d.MaxBytes == 0 {
+		d.MaxBytes = 100 * 1024 * 1024
+	}
+	if err := os.MkdirAll(filepath.Dir(d.Path), 0755); err != nil {
+		return fmt.Errorf("%v.path: %v", location, err)
+	}
+	file, err := os.OpenFile(d.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("%v.path: %v", location, err)
+	}
+	content, err := os.ReadFile(d.Path)
+	if err != nil {
+		_ = file.Close() // untested section
+		return fmt.Errorf("%v.path: %v", location, err)
+	}
+	d.file = file
+	d.size = int64(len(content))
+	d.queued = int64(bytes.Count(content, []byte("\n")))
+	return nil
+}
+
+// Write a log, dropping it when the buffer is full
+func (d *DiskBuffer) Write(line []byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.size+int64(len(line))+1 > d.MaxBytes {
+		atomic.AddUint64(&d.dropped, 1)
+		return
+	}
+	written, err := d.file.Write(append(line, '\n'))
+	d.size += int64(written)
+	if err != nil {
+		// untested section
+		atomic.AddUint64(&d.dropped, 1)
+		_, _ = fmt.Fprintf(os.Stderr, "Error: writing to buffer %v: %v\n", d.Path, err.Error())
+		return
+	}
+	atomic.AddInt64(&d.queued, 1)
+}
+
+// Take all buffered logs, Restore the ones that could not be sent
+func (d *DiskBuffer) Take() [][]byte {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if atomic.LoadInt64(&d.queued) == 0 {
+		return nil
+	}
+	content, err := os.ReadFile(d.Path)
+	if err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: reading buffer %v: %v\n", d.Path, err.Error())
+		return nil
+	}
+	d.truncate()
+	return bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+}
+
+// Restore logs in front of the logs that were buffered since they were taken
+func (d *DiskBuffer) Restore(lines [][]byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	content, err := os.ReadFile(d.Path)
+	if err != nil {
+		content = nil // untested section
+	}
+	restored := append(bytes.Join(lines, []byte("\n")), '\n')
+	d.truncate()
+	written, _ := d.file.Write(append(restored, content...))
+	d.size = int64(written)
+	atomic.StoreInt64(&d.queued, int64(len(lines)+bytes.Count(content, []byte("\n"))))
+}
+
+func (d *DiskBuffer) truncate() {
+	_ = d.file.Truncate(0)
+	d.size = 0
+	atomic.StoreInt64(&d.queued, 0)
+}
+
+func (d *DiskBuffer) Queued() int64 {
+	return atomic.LoadInt64(&d.queued)
+}
+
+func (d *DiskBuffer) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *DiskBuffer) Close() {
+	_ = d.file.Close()
+}
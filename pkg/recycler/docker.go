@@ -0,0 +1,39 @@
+package recycler
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// {"log":"message\n","stream":"stderr","time":"2024-01-01T00:00:00.000000000Z"}
+type dockerLine struct {
+	Log    *string
+	Stream string
+	Time   string
+}
+
+// unwrap lines of the docker json-file logging driver and capture timestamp and stream
+func captureDocker(config *Config, log *OrderedMap) {
+	message := log.values[config.MessageKey]
+	if !strings.HasPrefix(message, "{") {
+		return
+	}
+	var line dockerLine
+	if err := json.Unmarshal([]byte(message), &line); err != nil || line.Log == nil {
+		return
+	}
+	log.values[config.MessageKey] = strings.TrimRight(*line.Log, "\r\n")
+	if config.timestampKeySet {
+		if parsed, err := time.Parse(time.RFC3339Nano, line.Time); err == nil {
+			log.values[config.TimestampKey] = parsed.Format(timeFormat)
+		}
+	}
+	if line.Stream != "" {
+		if config.streamKeySet {
+			log.Set(config.StreamKey, line.Stream)
+		} else {
+			log.Set("stream", line.Stream)
+		}
+	}
+}
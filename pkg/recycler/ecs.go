@@ -0,0 +1,30 @@
+package recycler
+
+// well-known captures and their elastic common schema names https://www.elastic.co/guide/en/ecs/current/ecs-field-reference.html
+var ecsFields = map[string]string{
+	"ip":         "client.ip",
+	"method":     "http.request.method",
+	"status":     "http.response.status_code",
+	"duration":   "event.duration",
+	"path":       "url.path",
+	"url":        "url.original",
+	"user_agent": "user_agent.original",
+	"pid":        "process.pid",
+	"logger":     "log.logger",
+}
+
+// use ecs names for standard keys and rename well-known captures, unless they are renamed explicitly
+func applyEcs(config *Config) {
+	config.TimestampKey = "@timestamp"
+	config.LevelKey = "log.level"
+	config.MessageKey = "message"
+
+	if config.Rename == nil {
+		config.Rename = map[string]string{}
+	}
+	for from, to := range ecsFields {
+		if _, found := config.Rename[from]; !found {
+			config.Rename[from] = to
+		}
+	}
+}
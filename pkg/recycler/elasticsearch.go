@@ -0,0 +1,147 @@
+package recycler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Elasticsearch indexes logs in batches via https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+type Elasticsearch struct {
+	Url       string
+	Index     string // supports date math like logs-%{+yyyy.MM.dd}
+	Username  string
+	Password  string
+	BatchSize int           `yaml:"batchSize"`
+	BatchWait time.Duration `yaml:"batchWait"`
+	Retries   *int          // shorthand for retry.attempts
+	Retry     *Retry
+	Buffer    *DiskBuffer
+	batcher   *Batcher[elasticsearchDocument]
+	client    *http.Client
+}
+
+// exported fields so it can be buffered on disk
+type elasticsearchDocument struct {
+	Index  string `json:"index"`
+	Source string `json:"source"`
+}
+
+type elasticsearchResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+var elasticsearchDateRegex = regexp.MustCompile(`%\{\+([^}]+)\}`)
+var elasticsearchDateFormat = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15", "mm", "04", "ss", "05")
+
+func (e *Elasticsearch) Start() {
+	if e.BatchSize == 0 {
+		e.BatchSize = 500
+	}
+	if e.BatchWait == 0 {
+		e.BatchWait = time.Second
+	}
+	e.client = &http.Client{Timeout: 30 * time.Second}
+	e.batcher = NewBufferedBatcher(e.BatchSize, e.BatchWait, e.Buffer, e.send)
+}
+
+// Stop sends all remaining logs
+func (e *Elasticsearch) Stop() {
+	e.batcher.Stop()
+}
+
+// Push a log, blocking when elasticsearch cannot keep up
+func (e *Elasticsearch) Push(log *OrderedMap) {
+	e.batcher.Add(elasticsearchDocument{Index: e.indexName(time.Now()), Source: log.ToJson()})
+}
+
+// replace date math with the given time
+func (e *Elasticsearch) indexName(now time.Time) string {
+	return elasticsearchDateRegex.ReplaceAllStringFunc(e.Index, func(match string) string {
+		layout := elasticsearchDateFormat.Replace(elasticsearchDateRegex.FindStringSubmatch(match)[1])
+		return now.UTC().Format(layout)
+	})
+}
+
+func (e *Elasticsearch) send(batch []elasticsearchDocument) error {
+	pending := batch
+	return e.Retry.Send("sending to elasticsearch", func() (bool, error) {
+		rejected, retry, err := e.post(pending)
+		if len(rejected) != 0 {
+			pending = rejected // the other documents were indexed
+		}
+		return retry, err
+	})
+}
+
+// returns the documents that were rejected because elasticsearch was overloaded and if the request should be retried
+func (e *Elasticsearch) post(documents []elasticsearchDocument) ([]elasticsearchDocument, bool, error) {
+	var body bytes.Buffer
+	for _, document := range documents {
+		body.WriteString(`{"index":{"_index":` + jsonString(document.Index) + "}}\n")
+		body.WriteString(document.Source + "\n")
+	}
+	request, err := http.NewRequest("POST", strings.TrimRight(e.Url, "/")+"/_bulk", &body)
+	if err != nil {
+		return nil, false, err // untested section
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if e.Username != "" {
+		request.SetBasicAuth(e.Username, e.Password)
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return nil, true, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, true, err // untested section
+	}
+
+	if response.StatusCode == 429 || response.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("status %v", response.StatusCode)
+	}
+	if response.StatusCode >= 300 {
+		return nil, false, fmt.Errorf("status %v: %v", response.StatusCode, string(content))
+	}
+
+	// individual documents can fail, the ones rejected with 429 (es_rejected_execution_exception) are sent again
+	var parsed elasticsearchResponse
+	if err = json.Unmarshal(content, &parsed); err != nil {
+		return nil, false, err
+	}
+	if !parsed.Errors {
+		return nil, false, nil
+	}
+	var rejected []elasticsearchDocument
+	var failed error
+	for i, item := range parsed.Items {
+		for _, result := range item {
+			if result.Status == 429 && i < len(documents) {
+				rejected = append(rejected, documents[i])
+			} else if result.Status >= 300 && failed == nil {
+				failed = fmt.Errorf("status %v: %v", result.Status, string(result.Error))
+			}
+		}
+	}
+	if len(rejected) == 0 {
+		return nil, false, failed
+	}
+	if failed != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: sending to elasticsearch: %v\n", failed.Error())
+	}
+	return rejected, true, fmt.Errorf("status 429 for %d documents", len(rejected))
+}
@@ -0,0 +1,309 @@
+package recycler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ExecFilter pipes each log as json through a long-running command and merges the fields it returns,
+// so teams can write custom enrichment in any language
+type ExecFilter struct {
+	Command   []string
+	Framing   string        // lines: one json per line, length: 4 byte big endian length before each json (default lines)
+	Timeout   time.Duration // to wait for a response, the command is restarted after (default 1s)
+	BatchSize int           `yaml:"batchSize"` // send json arrays of up to this many logs, 1 sends each log alone (default 1)
+	BatchWait time.Duration `yaml:"batchWait"` // to wait for a batch to fill up (default 100ms)
+	lock      sync.Mutex
+	process   *exec.Cmd // nil until the first log or after it failed
+	stdin     io.WriteCloser
+	responses chan []byte
+	batchLock sync.Mutex
+	batch     []batchedLog // waiting to be sent
+	batches   int          // sent so far, so a late timer does not send the next batch early
+}
+
+// log waiting in a batch, gets its response or nil when the command failed
+type batchedLog struct {
+	request  []byte
+	response chan []byte
+}
+
+func (f *ExecFilter) validate() error {
+	if len(f.Command) == 0 {
+		return fmt.Errorf("execFilter.command must be set")
+	}
+	switch f.Framing {
+	case "":
+		f.Framing = "lines"
+	case "lines", "length":
+	default:
+		return fmt.Errorf("execFilter.framing must be lines or length but was %v", f.Framing)
+	}
+	if f.Timeout == 0 {
+		f.Timeout = time.Second
+	}
+	if f.BatchSize < 0 {
+		return fmt.Errorf("execFilter.batchSize must be 0 or more but was %d", f.BatchSize)
+	}
+	if err := validateBatchWait("execFilter", f.BatchWait); err != nil {
+		return err
+	}
+	if f.BatchWait == 0 {
+		f.BatchWait = 100 * time.Millisecond
+	}
+	return nil
+}
+
+// Apply sends the log to the command and merges the fields of the returned json object, null removes a field,
+// the log is kept as is when the command fails
+func (f *ExecFilter) Apply(log *OrderedMap) {
+	request := []byte(log.ToJson())
+	var response []byte
+	if f.BatchSize > 1 {
+		response = f.batched(request)
+	} else {
+		response = f.single(request)
+	}
+	if response == nil {
+		return
+	}
+	if err := mergeFilterResponse(log, response); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: execFilter: parsing %q: %v\n", response, err.Error())
+	}
+}
+
+func (f *ExecFilter) single(request []byte) []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	response, err := f.exchange(request)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: execFilter: %v\n", err.Error())
+		f.kill()
+		return nil
+	}
+	return response
+}
+
+// wait until the batch of the log is full or batchWait passed, batches only fill up when logs
+// are processed concurrently, for example with workers or listen
+func (f *ExecFilter) batched(request []byte) []byte {
+	log := batchedLog{request: request, response: make(chan []byte, 1)}
+	f.batchLock.Lock()
+	f.batch = append(f.batch, log)
+	if len(f.batch) >= f.BatchSize {
+		batch := f.take()
+		f.batchLock.Unlock()
+		f.send(batch)
+	} else {
+		if len(f.batch) == 1 {
+			number := f.batches
+			time.AfterFunc(f.BatchWait, func() { f.flush(number) })
+		}
+		f.batchLock.Unlock()
+	}
+	return <-log.response
+}
+
+// send the batch when it was not sent because it filled up, -1 sends any batch
+func (f *ExecFilter) flush(number int) {
+	f.batchLock.Lock()
+	if number != -1 && number != f.batches || len(f.batch) == 0 {
+		f.batchLock.Unlock()
+		return
+	}
+	batch := f.take()
+	f.batchLock.Unlock()
+	f.send(batch)
+}
+
+// called with batchLock held
+func (f *ExecFilter) take() []batchedLog {
+	batch := f.batch
+	f.batch = nil
+	f.batches++
+	return batch
+}
+
+// send the batch as one json array and hand each log the object at its position in the returned array
+func (f *ExecFilter) send(batch []batchedLog) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	request := []byte{'['}
+	for i, log := range batch {
+		if i != 0 {
+			request = append(request, ',')
+		}
+		request = append(request, log.request...)
+	}
+	request = append(request, ']')
+
+	var responses []json.RawMessage
+	response, err := f.exchange(request)
+	if err == nil {
+		if err = json.Unmarshal(response, &responses); err != nil {
+			err = fmt.Errorf("parsing %q: %v", response, err)
+		} else if len(responses) != len(batch) {
+			err = fmt.Errorf("returned %d logs for a batch of %d", len(responses), len(batch))
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: execFilter: %v\n", err.Error())
+		f.kill()
+		for _, log := range batch {
+			log.response <- nil
+		}
+		return
+	}
+	for i, log := range batch {
+		log.response <- responses[i]
+	}
+}
+
+// Stop the command by closing its stdin after sending the waiting batch, killing it when it does not exit
+func (f *ExecFilter) Stop() {
+	f.flush(-1)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.process == nil {
+		return
+	}
+	_ = f.stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		_ = f.process.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(f.Timeout):
+		_ = f.process.Process.Kill() // untested section
+		<-exited
+	}
+	f.process = nil
+}
+
+func (f *ExecFilter) exchange(request []byte) ([]byte, error) {
+	if f.process == nil {
+		if err := f.start(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Framing == "length" {
+		request = append(binary.BigEndian.AppendUint32(nil, uint32(len(request))), request...)
+	} else {
+		request = append(request, '\n')
+	}
+	if _, err := f.stdin.Write(request); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(f.Timeout)
+	defer timeout.Stop()
+	select {
+	case response, open := <-f.responses:
+		if !open {
+			return nil, fmt.Errorf("%v exited", f.Command[0])
+		}
+		return response, nil
+	case <-timeout.C:
+		return nil, fmt.Errorf("%v did not respond within %v", f.Command[0], f.Timeout)
+	}
+}
+
+func (f *ExecFilter) start() error {
+	command := exec.Command(f.Command[0], f.Command[1:]...)
+	command.Stderr = os.Stderr
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err // untested section
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err // untested section
+	}
+	if err := command.Start(); err != nil {
+		return err
+	}
+
+	// buffered so a late response after a timeout does not block until the command is killed
+	responses := make(chan []byte, 1)
+	go func() {
+		defer close(responses)
+		reader := bufio.NewReader(stdout)
+		for {
+			response, err := f.read(reader)
+			if err != nil {
+				return
+			}
+			responses <- response
+		}
+	}()
+	f.process, f.stdin, f.responses = command, stdin, responses
+	return nil
+}
+
+func (f *ExecFilter) read(reader *bufio.Reader) ([]byte, error) {
+	if f.Framing == "length" {
+		var size uint32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		response := make([]byte, size)
+		_, err := io.ReadFull(reader, response)
+		return response, err
+	}
+	return reader.ReadBytes('\n')
+}
+
+// the command is out of sync or gone, start it again for the next log
+func (f *ExecFilter) kill() {
+	if f.process == nil {
+		return
+	}
+	_ = f.stdin.Close()
+	_ = f.process.Process.Kill()
+	_ = f.process.Wait()
+	f.process = nil
+}
+
+// strings are set as is, numbers and booleans keep their json type, objects and arrays are set as json
+func mergeFilterResponse(log *OrderedMap, response []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(response))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+	for _, key := range sortedMapKeys(fields) {
+		switch value := fields[key].(type) {
+		case nil:
+			log.Delete(key)
+		case string:
+			log.Set(key, value)
+			delete(log.types, key)
+		case json.Number:
+			log.Set(key, value.String())
+			if _, err := strconv.ParseInt(value.String(), 10, 64); err == nil {
+				log.SetType(key, "int")
+			} else {
+				log.SetType(key, "float")
+			}
+		case bool:
+			log.Set(key, strconv.FormatBool(value))
+			log.SetType(key, "bool")
+		default:
+			raw, _ := json.Marshal(value)
+			log.Set(key, string(raw))
+			delete(log.types, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package recycler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fingerprint groups messages into templates with their variable tokens masked (like Drain),
+// so logs of unknown shape can still be grouped and counted,
+// the fingerprint is the hash of the first message of a template, so it does not change while the template learns
+type Fingerprint struct {
+	Key          string  // field to set (default fingerprint)
+	TemplateKey  string  `yaml:"templateKey"` // also set the template, variable tokens are <*> (default not set)
+	Similarity   float64 // share of equal tokens to belong to a template (default 0.5)
+	MaxTemplates int     `yaml:"maxTemplates"` // then new shapes are "other" to keep memory and metrics bounded (default 1000)
+	MetricLabel  bool    `yaml:"metricLabel"`  // also use the fingerprint as metric label
+	lock         sync.Mutex
+	templates    map[string][]*logTemplate // by token count and first token, only these can be similar
+	count        int
+}
+
+type logTemplate struct {
+	id     string
+	tokens []string
+}
+
+func (f *Fingerprint) validate() error {
+	if f.Key == "" {
+		f.Key = "fingerprint"
+	}
+	if f.Similarity == 0 {
+		f.Similarity = 0.5
+	}
+	if f.Similarity < 0 || f.Similarity > 1 {
+		return fmt.Errorf("fingerprint.similarity must be between 0.0 - 1.0 but was %v", f.Similarity)
+	}
+	if f.MaxTemplates == 0 {
+		f.MaxTemplates = 1000
+	}
+	f.templates = map[string][]*logTemplate{}
+	return nil
+}
+
+// Apply sets the fingerprint of the message template, learning a new template when no template is similar enough
+func (f *Fingerprint) Apply(log *OrderedMap, messageKey string) {
+	tokens := strings.Fields(maskMessage(log.values[messageKey]))
+	group := strconv.Itoa(len(tokens))
+	if len(tokens) != 0 {
+		group += " " + tokens[0]
+	}
+
+	f.lock.Lock()
+	var best *logTemplate
+	bestSimilarity := -1.0
+	for _, template := range f.templates[group] {
+		if similarity := templateSimilarity(template.tokens, tokens); similarity > bestSimilarity {
+			best, bestSimilarity = template, similarity
+		}
+	}
+	id, template := "other", ""
+	if best != nil && bestSimilarity >= f.Similarity {
+		for i, token := range tokens {
+			if best.tokens[i] != token {
+				best.tokens[i] = "<*>"
+			}
+		}
+		id, template = best.id, strings.Join(best.tokens, " ")
+	} else if f.count < f.MaxTemplates {
+		template = strings.Join(tokens, " ")
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(template))
+		id = fmt.Sprintf("%08x", hash.Sum32())
+		f.templates[group] = append(f.templates[group], &logTemplate{id: id, tokens: tokens})
+		f.count++
+	}
+	f.lock.Unlock()
+
+	log.Set(f.Key, id)
+	if f.TemplateKey != "" {
+		log.Set(f.TemplateKey, template)
+	}
+}
+
+// share of tokens that are equal, tokens that are already variable do not count
+func templateSimilarity(template []string, tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+	equal := 0
+	for i, token := range tokens {
+		if template[i] == token {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens))
+}
@@ -0,0 +1,100 @@
+package recycler
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoIp adds country, city and autonomous system of an ip field from MaxMind GeoLite2 databases
+// https://dev.maxmind.com/geoip/geolite2-free-geolocation-data
+type GeoIp struct {
+	Field  string // field with the ip
+	Prefix string // prefix of the added fields (default field name + _)
+	City   string // GeoLite2-City or GeoLite2-Country database, adds country and city
+	Asn    string // GeoLite2-ASN database, adds asn and as_org
+	city   *geoip2.Reader
+	asn    *geoip2.Reader
+}
+
+func (g *GeoIp) validate(location string) error {
+	if g.Field == "" {
+		return fmt.Errorf("%v.field must be set", location)
+	}
+	if g.City == "" && g.Asn == "" {
+		return fmt.Errorf("%v must set one of city or asn", location)
+	}
+	if g.Prefix == "" {
+		g.Prefix = g.Field + "_"
+	}
+
+	var err error
+	if g.City != "" {
+		if g.city, err = geoip2.Open(g.City); err != nil {
+			return fmt.Errorf("%v.city: %v", location, err)
+		}
+	}
+	if g.Asn != "" {
+		if g.asn, err = geoip2.Open(g.Asn); err != nil {
+			return fmt.Errorf("%v.asn: %v", location, err)
+		}
+	}
+	return nil
+}
+
+// fields that can be added
+func (g *GeoIp) keys() []string {
+	keys := []string{}
+	if g.City != "" {
+		keys = append(keys, g.Prefix+"country")
+		if !g.countryOnly() {
+			keys = append(keys, g.Prefix+"city")
+		}
+	}
+	if g.Asn != "" {
+		keys = append(keys, g.Prefix+"asn", g.Prefix+"as_org")
+	}
+	return keys
+}
+
+func (g *GeoIp) countryOnly() bool {
+	return g.city != nil && strings.Contains(g.city.Metadata().DatabaseType, "Country")
+}
+
+// Enrich the log when it has a known ip, unknown values are not added
+func (g *GeoIp) Enrich(log *OrderedMap) {
+	value, found := log.values[g.Field]
+	if !found {
+		return
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return
+	}
+
+	if g.city != nil {
+		if g.countryOnly() {
+			if record, err := g.city.Country(ip); err == nil {
+				setIfPresent(log, g.Prefix+"country", record.Country.IsoCode)
+			}
+		} else if record, err := g.city.City(ip); err == nil {
+			setIfPresent(log, g.Prefix+"country", record.Country.IsoCode)
+			setIfPresent(log, g.Prefix+"city", record.City.Names["en"])
+		}
+	}
+	if g.asn != nil {
+		if record, err := g.asn.ASN(ip); err == nil && record.AutonomousSystemNumber != 0 {
+			log.Set(g.Prefix+"asn", strconv.FormatUint(uint64(record.AutonomousSystemNumber), 10))
+			setIfPresent(log, g.Prefix+"as_org", record.AutonomousSystemOrganization)
+		}
+	}
+}
+
+func setIfPresent(log *OrderedMap, key string, value string) {
+	if value != "" {
+		log.Set(key, value)
+	}
+}
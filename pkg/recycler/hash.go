@@ -0,0 +1,59 @@
+package recycler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"os"
+)
+
+// Hash pseudonymizes fields like user ids and emails, equal values stay equal so they can still be correlated
+type Hash struct {
+	Fields    []string // fields to replace with their hex hash
+	Algorithm string   // sha256 or hmac (default sha256)
+	SaltEnv   string   `yaml:"saltEnv"` // environment variable with the salt, required for hmac
+	salt      []byte
+}
+
+func (h *Hash) validate() error {
+	if len(h.Fields) == 0 {
+		return fmt.Errorf("hash.fields must be set")
+	}
+	switch h.Algorithm {
+	case "":
+		h.Algorithm = "sha256"
+	case "sha256", "hmac":
+	default:
+		return fmt.Errorf("hash.algorithm must be sha256 or hmac but was %v", h.Algorithm)
+	}
+	if h.SaltEnv != "" {
+		h.salt = []byte(os.Getenv(h.SaltEnv))
+		if len(h.salt) == 0 {
+			return fmt.Errorf("hash.saltEnv %v is not set", h.SaltEnv)
+		}
+	} else if h.Algorithm == "hmac" {
+		return fmt.Errorf("hash.saltEnv must be set for hmac")
+	}
+	return nil
+}
+
+// Apply replaces the fields the log has with their hash
+func (h *Hash) Apply(log *OrderedMap) {
+	for _, field := range h.Fields {
+		if value, found := log.values[field]; found {
+			log.values[field] = h.sum(value)
+		}
+	}
+}
+
+func (h *Hash) sum(value string) string {
+	if h.Algorithm == "hmac" {
+		mac := hmac.New(sha256.New, h.salt)
+		mac.Write([]byte(value))
+		return fmt.Sprintf("%x", mac.Sum(nil))
+	}
+	digest := sha256.New()
+	digest.Write(h.salt)
+	digest.Write([]byte(value))
+	return fmt.Sprintf("%x", digest.Sum(nil))
+}
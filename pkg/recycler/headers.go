@@ -0,0 +1,151 @@
+package recycler
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderFormats are the log header formats that `glog:` strips, accepts a single format or a list
+type HeaderFormats []string
+
+type headerFormat struct {
+	regex   *regexp.Regexp
+	capture func(config *Config, match []string, log *OrderedMap)
+}
+
+var headerFormatNames = []string{"glog", "klog", "zap", "logrus-text"}
+var headerFormats = map[string]headerFormat{
+	// I0203 02:03:04.12345    123 foo.go:123] message
+	"glog": {
+		regexp.MustCompile(`^([IWEF])(\d{2})(\d{2}) (\d{2}):(\d{2}):(\d{2})\.\d+ +\d+ \S+:\d+] `),
+		captureGlog,
+	},
+	// like glog, but the thread id can be missing and the message can be empty
+	// I0203 02:03:04.123456 foo.go:123] message
+	"klog": {
+		regexp.MustCompile(`^([IWEF])(\d{2})(\d{2}) (\d{2}):(\d{2}):(\d{2})\.\d+ +(?:\d+ +)?\S+:\d+\] ?`),
+		captureGlog,
+	},
+	// zap console encoder, logger name and caller are optional
+	// 2020-02-03T02:03:04.123Z	INFO	logger	foo.go:12	message
+	"zap": {
+		regexp.MustCompile(`^(\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(?:\.\d+)?(?:Z|[+-]\d\d:?\d\d))\t([A-Z]+)\t(?:[^\t:]+\t)?(?:[^\t]+:\d+\t)?`),
+		captureZap,
+	},
+	// logrus text formatter, other keys are captured as fields
+	// time="2020-02-03T02:03:04Z" level=info msg="message" foo=bar
+	"logrus-text": {
+		regexp.MustCompile(`^time="([^"]*)" level=(\w+) msg=("(?:[^"\\]|\\.)*"|\S*) ?`),
+		captureLogrus,
+	},
+}
+
+var glogLevels = map[string]string{
+	"I": "INFO",
+	"W": "WARN",
+	"E": "ERROR",
+	"F": "FATAL",
+}
+
+var logrusFieldRegex = regexp.MustCompile(`(\w+)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+// UnmarshalYAML supports the legacy `glog: simple` and lists of formats
+func (h *HeaderFormats) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var format string
+	if err := unmarshal(&format); err == nil {
+		if format == "simple" {
+			format = "glog"
+		}
+		if format == "" {
+			*h = HeaderFormats{}
+		} else {
+			*h = HeaderFormats{format}
+		}
+		return nil
+	}
+
+	var formats []string
+	if err := unmarshal(&formats); err != nil {
+		return err
+	}
+	*h = formats
+	return nil
+}
+
+// strip the first matching header
+// returns the name of the header that was found or "" when none matched
+func captureHeader(config *Config, log *OrderedMap) string {
+	for _, name := range config.Glog {
+		format := headerFormats[name]
+		if match := format.regex.FindStringSubmatch(log.values[config.MessageKey]); match != nil {
+			format.capture(config, match, log)
+			return name
+		}
+	}
+	return ""
+}
+
+func captureGlog(config *Config, match []string, log *OrderedMap) {
+	// remove glog from message
+	log.values[config.MessageKey] = log.values[config.MessageKey][len(match[0]):]
+
+	// set level
+	if config.levelKeySet {
+		log.values[config.LevelKey] = glogLevels[match[1]]
+	}
+
+	// parse time
+	if config.timestampKeySet {
+		year := time.Now().Year()
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		hour, _ := strconv.Atoi(match[4])
+		min, _ := strconv.Atoi(match[5])
+		sec, _ := strconv.Atoi(match[6])
+		date := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.UTC)
+		log.values[config.TimestampKey] = date.Format(timeFormat)
+	}
+}
+
+func captureZap(config *Config, match []string, log *OrderedMap) {
+	log.values[config.MessageKey] = log.values[config.MessageKey][len(match[0]):]
+	captureHeaderLevelAndTime(config, match[2], match[1], log)
+}
+
+func captureLogrus(config *Config, match []string, log *OrderedMap) {
+	message := unquoteLogrus(match[3])
+	rest := log.values[config.MessageKey][len(match[0]):]
+	log.values[config.MessageKey] = message
+	captureHeaderLevelAndTime(config, match[2], match[1], log)
+
+	for _, field := range logrusFieldRegex.FindAllStringSubmatch(rest, -1) {
+		log.Set(field[1], unquoteLogrus(field[2]))
+	}
+}
+
+func unquoteLogrus(value string) string {
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		return unquoted
+	}
+	return value
+}
+
+func captureHeaderLevelAndTime(config *Config, level string, timestamp string, log *OrderedMap) {
+	if config.levelKeySet {
+		level = strings.ToUpper(level)
+		if level == "WARNING" {
+			level = "WARN"
+		}
+		log.values[config.LevelKey] = level
+	}
+
+	if config.timestampKeySet {
+		if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			log.values[config.TimestampKey] = parsed.Format(timeFormat)
+		} else if parsed, err := time.Parse("2006-01-02T15:04:05.999999999Z0700", timestamp); err == nil {
+			log.values[config.TimestampKey] = parsed.Format(timeFormat)
+		}
+	}
+}
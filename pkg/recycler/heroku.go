@@ -0,0 +1,42 @@
+package recycler
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 83 <40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed from starting to up
+// logplex drains frame rfc5424 lines with their length and leave out structured data
+var herokuRegex = regexp.MustCompile(`^(?:\d+ )?<\d{1,3}>1 (\S+) \S+ (\S+) (\S+) \S+ ?`)
+
+// strip logplex framing, capture timestamp, source (app or heroku) and dyno, and parse router key=value pairs
+// https://devcenter.heroku.com/articles/http-routing#heroku-router-log-format
+func captureHeroku(config *Config, log *OrderedMap) {
+	match := herokuRegex.FindStringSubmatch(log.values[config.MessageKey])
+	if match == nil {
+		return
+	}
+	message := log.values[config.MessageKey][len(match[0]):]
+	log.values[config.MessageKey] = message
+	if config.timestampKeySet {
+		if parsed, err := time.Parse(time.RFC3339Nano, match[1]); err == nil {
+			log.values[config.TimestampKey] = parsed.Format(timeFormat)
+		}
+	}
+	log.Set("source", match[2])
+	log.Set("dyno", match[3])
+
+	if match[2] != "heroku" || match[3] != "router" {
+		return
+	}
+	parseLogfmt(message, func(key string, value string) {
+		if key == "at" {
+			if config.levelKeySet {
+				log.values[config.LevelKey] = strings.ToUpper(value)
+			}
+			return
+		}
+		log.Set(key, value) // dyno is the dyno that served the request
+	})
+}
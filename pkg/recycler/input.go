@@ -0,0 +1,233 @@
+package recycler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// how often to check followed files for new content / rotation
+var followInterval = 250 * time.Millisecond
+
+// Line is a line that was read, Truncated when it was longer than maxLineLength
+type Line struct {
+	Text      string
+	Truncated bool
+	Stream    string      // stdout or stderr when reading from a command
+	Fields    *OrderedMap // set by inputs that know more than the message, like journald
+}
+
+// Input is a file, a syslog listener or the systemd journal configured via `inputs` that is read instead of stdin
+type Input struct {
+	Path     string
+	Follow   bool
+	Listen   string // address to receive syslog messages or http requests on
+	Protocol string // udp, tcp or http
+	Journald bool
+	Units    []string // only read journal entries of these units
+	Priority string   // only read journal entries with this priority or more severe
+	file     *os.File
+	stop     chan struct{}
+	listener *listener
+	journal  *journal
+}
+
+// Open the file before reading so misconfiguration fails at startup
+func (i *Input) Open() error {
+	if i.Listen != "" {
+		return i.listen()
+	}
+	if i.Journald {
+		return i.openJournal()
+	}
+
+	file, err := os.Open(i.Path)
+	if err != nil {
+		return err
+	}
+
+	// like `tail -F -n0` only new lines are read when following
+	if i.Follow {
+		if _, err = file.Seek(0, io.SeekEnd); err != nil {
+			_ = file.Close() // untested section
+			return err
+		}
+	}
+
+	i.file = file
+	i.stop = make(chan struct{})
+	return nil
+}
+
+// Stop following, ReadLines returns after the current poll
+func (i *Input) Stop() {
+	if i.listener != nil {
+		i.listener.Stop()
+		return
+	}
+	if i.journal != nil {
+		i.journal.Stop()
+		return
+	}
+	close(i.stop)
+}
+
+// ReadLines reads all lines of the file into the channel, waiting for more lines and reopening rotated files when following
+func (i *Input) ReadLines(lines chan<- Line, config *Config) {
+	if i.listener != nil {
+		i.listener.ReadLines(lines, config, i.Protocol)
+		return
+	}
+	if i.journal != nil {
+		i.journal.ReadLines(lines, config)
+		return
+	}
+	defer func() { _ = i.file.Close() }()
+	readLines(i, "", lines, config)
+}
+
+// Read from the file, when following wait for more content instead of returning io.EOF
+func (i *Input) Read(p []byte) (int, error) {
+	for {
+		n, err := i.file.Read(p)
+		if err != io.EOF || !i.Follow {
+			return n, err
+		}
+		if n != 0 {
+			return n, nil
+		}
+
+		select {
+		case <-i.stop:
+			return 0, io.EOF
+		case <-time.After(followInterval):
+		}
+
+		i.reopen()
+	}
+}
+
+// reopen the file when it was truncated or rotated
+func (i *Input) reopen() {
+	current, err := i.file.Stat()
+	if err != nil {
+		return // untested section
+	}
+
+	latest, err := os.Stat(i.Path)
+	if err != nil {
+		return // rotated but new file not created yet
+	}
+
+	offset, err := i.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return // untested section
+	}
+
+	// rotated: read lines that were written to the old file before it was rotated, then continue with the new one from the start
+	if !os.SameFile(current, latest) {
+		if offset < current.Size() {
+			return
+		}
+		file, err := os.Open(i.Path)
+		if err != nil {
+			return // untested section
+		}
+		_ = i.file.Close()
+		i.file = file
+		return
+	}
+
+	// truncated: start over
+	if latest.Size() < offset {
+		_, _ = i.file.Seek(0, io.SeekStart)
+	}
+}
+
+// lineSplitter finds lines for a bufio.Scanner, lines longer than maxLineLength are cut
+// and their rest is the next line or discarded when truncating,
+// the fragments of a cut line get the split idKey and indexKey like the parts of a split line
+type lineSplitter struct {
+	config     *Config
+	truncated  bool   // last token is the start of a truncated line
+	skipping   bool   // discarding the rest of a truncated line
+	fragmented bool   // last token is a fragment of a cut line
+	index      int    // of the last token in the cut line
+	fragments  int    // of the current cut line so far, 0 when the line was not cut
+	id         string // shared by the fragments of the current cut line
+}
+
+func (s *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	if s.skipping {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			s.skipping = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token == nil && err == nil && len(data) > s.config.MaxLineLength {
+		// line is too long for the buffer, so cut it, but not inside of a multi-byte character
+		cut := s.config.MaxLineLength
+		for cut > 0 && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = s.config.MaxLineLength // untested section
+		}
+		advance, token = cut, data[:cut]
+		if s.config.TruncateLongLines {
+			s.truncated, s.skipping = true, true
+			return advance, token, err
+		}
+		if s.fragments == 0 {
+			s.id = newSplitId()
+		}
+		s.fragmented, s.index = true, s.fragments
+		s.fragments++
+		return advance, token, err
+	}
+	if token != nil && s.fragments != 0 {
+		// rest of the cut line
+		s.fragmented, s.index, s.fragments = true, s.fragments, 0
+	}
+	return advance, token, err
+}
+
+// line of the last token, fragments of a cut line get the split id and index fields
+func (s *lineSplitter) line(text string) Line {
+	line := Line{Text: text, Truncated: s.truncated}
+	if s.fragmented && s.config.Split != nil {
+		line.Fields = s.config.Split.fields(line, s.id, s.index)
+	}
+	s.truncated, s.fragmented = false, false
+	return line
+}
+
+// readLines reads a stream line by line into the channel, splitting or truncating lines longer than maxLineLength
+func readLines(reader io.Reader, stream string, lines chan<- Line, config *Config) {
+	// +1 so lines of exactly the max length can be found with their newline
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, min(4096, config.MaxLineLength+1)), config.MaxLineLength+1)
+	splitter := &lineSplitter{config: config}
+	scanner.Split(splitter.split)
+
+	for scanner.Scan() {
+		line := splitter.line(scanner.Text())
+		line.Stream = stream
+		lines <- line
+	}
+
+	if err := scanner.Err(); err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: reading input: %v\n", err.Error())
+		if config.telemetry != nil {
+			config.telemetry.ReadError()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package recycler
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Input", func() {
+	var lines chan Line
+	var config *Config
+
+	BeforeEach(func() {
+		followInterval = time.Millisecond
+		lines = make(chan Line, 10)
+		config = &Config{MaxLineLength: 1024}
+	})
+
+	follow := func(path string, fn func(input *Input)) {
+		input := &Input{Path: path, Follow: true}
+		Expect(input.Open()).To(BeNil())
+		done := make(chan struct{})
+		go func() {
+			input.ReadLines(lines, config)
+			close(done)
+		}()
+		fn(input)
+		input.Stop()
+		<-done
+	}
+
+	appendFile := func(path string, content string) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).To(BeNil())
+		_, err = file.WriteString(content)
+		Expect(err).To(BeNil())
+		Expect(file.Close()).To(BeNil())
+	}
+
+	It("reads the whole file", func() {
+		withFile("a\r\nb\n\nc", func(path string) {
+			input := &Input{Path: path}
+			Expect(input.Open()).To(BeNil())
+			input.ReadLines(lines, config)
+			close(lines)
+			Expect(collect(lines)).To(Equal([]string{"a", "b", "", "c"}))
+		})
+	})
+
+	It("fails to open missing files", func() {
+		input := &Input{Path: "/nope"}
+		Expect(input.Open()).ToNot(BeNil())
+	})
+
+	It("follows new lines only", func() {
+		withFile("old\n", func(path string) {
+			follow(path, func(input *Input) {
+				appendFile(path, "new\npart")
+				Eventually(lines).Should(Receive(Equal(Line{Text: "new"})))
+				appendFile(path, "ial\n")
+				Eventually(lines).Should(Receive(Equal(Line{Text: "partial"})))
+			})
+		})
+	})
+
+	It("follows truncated files", func() {
+		withFile("old\n", func(path string) {
+			follow(path, func(input *Input) {
+				Expect(ioutil.WriteFile(path, []byte("a\n"), 0644)).To(BeNil())
+				Eventually(lines).Should(Receive(Equal(Line{Text: "a"})))
+			})
+		})
+	})
+
+	It("follows rotated files", func() {
+		withFile("old\n", func(path string) {
+			follow(path, func(input *Input) {
+				Expect(os.Rename(path, path+".1")).To(BeNil())
+				defer os.Remove(path + ".1")
+				appendFile(path+".1", "last\n")
+				Expect(ioutil.WriteFile(path, []byte("first\n"), 0644)).To(BeNil())
+				Eventually(lines).Should(Receive(Equal(Line{Text: "last"})))
+				Eventually(lines).Should(Receive(Equal(Line{Text: "first"})))
+			})
+		})
+	})
+
+	It("reads the rest of rotated files before switching to the new file", func() {
+		withFile("old\n", func(path string) {
+			input := &Input{Path: path, Follow: true}
+			Expect(input.Open()).To(BeNil())
+			appendFile(path, "last\n")
+			Expect(os.Rename(path, path+".1")).To(BeNil())
+			defer os.Remove(path + ".1")
+			Expect(ioutil.WriteFile(path, []byte("first\n"), 0644)).To(BeNil())
+			input.reopen() // rotation is noticed before the old file was read to the end
+
+			done := make(chan struct{})
+			go func() {
+				input.ReadLines(lines, config)
+				close(done)
+			}()
+			Eventually(lines).Should(Receive(Equal(Line{Text: "last"})))
+			Eventually(lines).Should(Receive(Equal(Line{Text: "first"})))
+			input.Stop()
+			<-done
+		})
+	})
+
+	It("reads the journal via journalctl", func() {
+		script := "#!/bin/sh\n" +
+			"echo '{\"MESSAGE\":\"hi\",\"PRIORITY\":\"3\",\"_SYSTEMD_UNIT\":\"a.service\",\"__REALTIME_TIMESTAMP\":\"1600000000000000\"}'\n" +
+			"echo '{\"MESSAGE\":[104,111]}'\n" +
+			"exec sleep 10\n"
+		withFile(script, func(path string) {
+			Expect(os.Chmod(path, 0755)).To(BeNil())
+			before := journalctl
+			journalctl = path
+			defer func() { journalctl = before }()
+
+			config.TimestampKey, config.timestampKeySet = "ts", true
+			config.LevelKey, config.levelKeySet = "level", true
+			input := &Input{Journald: true, Units: []string{"a.service"}, Priority: "err"}
+			Expect(input.Open()).To(BeNil())
+			done := make(chan struct{})
+			go func() {
+				input.ReadLines(lines, config)
+				close(done)
+			}()
+
+			var line Line
+			Eventually(lines).Should(Receive(&line))
+			Expect(line.Text).To(Equal("hi"))
+			Expect(line.Fields.ToJson()).To(Equal(`{"ts":"2020-09-13T12:26:40Z","level":"ERROR","unit":"a.service"}`))
+			Eventually(lines).Should(Receive(&line))
+			Expect(line.Text).To(Equal("ho"))
+
+			input.Stop()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+})
+
+func collect(lines chan Line) []string {
+	all := []string{}
+	for line := range lines {
+		all = append(all, line.Text)
+	}
+	return all
+}
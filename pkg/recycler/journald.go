@@ -0,0 +1,126 @@
+package recycler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// journal reads entries of the systemd journal for an Input with `journald`,
+// using journalctl so no cgo is needed for the sdjournal api
+type journal struct {
+	command *exec.Cmd
+	stdout  io.ReadCloser
+}
+
+// command to read the journal, replaced in tests
+var journalctl = "journalctl"
+
+var journalPriorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug", "0", "1", "2", "3", "4", "5", "6", "7"}
+
+// journal fields that are added to the log and what to call them, like the syslog fields
+var journalFields = [][2]string{
+	{"_SYSTEMD_UNIT", "unit"},
+	{"_HOSTNAME", "host"},
+	{"SYSLOG_IDENTIFIER", "tag"},
+	{"_PID", "pid"},
+}
+
+// start journalctl so a missing journalctl fails at startup, only new entries are read like `journalctl -f -n0`
+func (i *Input) openJournal() error {
+	args := []string{"--output", "json", "--follow", "--lines", "0"}
+	for _, unit := range i.Units {
+		args = append(args, "--unit", unit)
+	}
+	if i.Priority != "" {
+		args = append(args, "--priority", i.Priority)
+	}
+	command := exec.Command(journalctl, args...)
+	command.Stderr = os.Stderr
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err // untested section
+	}
+	if err = command.Start(); err != nil {
+		return err
+	}
+	i.journal = &journal{command: command, stdout: stdout}
+	return nil
+}
+
+// stop journalctl, entries that were already read are still processed
+func (j *journal) Stop() {
+	_ = j.command.Process.Kill()
+}
+
+// read entries until journalctl exits
+func (j *journal) ReadLines(lines chan<- Line, config *Config) {
+	defer func() { _ = j.command.Wait() }()
+
+	// entries are json encoded, so allow for escaping of the message
+	scanner := bufio.NewScanner(j.stdout)
+	scanner.Buffer(make([]byte, 0, 4096), 2*config.MaxLineLength+4096)
+	for scanner.Scan() {
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: reading journal: %v\n", err.Error())
+			if config.telemetry != nil {
+				config.telemetry.ReadError()
+			}
+			continue
+		}
+		lines <- journalLine(entry, config)
+	}
+	if err := scanner.Err(); err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: reading journal: %v\n", err.Error())
+		if config.telemetry != nil {
+			config.telemetry.ReadError()
+		}
+		_, _ = io.Copy(io.Discard, j.stdout) // do not block journalctl
+	}
+}
+
+// message of the entry with its time, priority and well-known fields
+func journalLine(entry map[string]json.RawMessage, config *Config) Line {
+	line := receivedLine(journalValue(entry["MESSAGE"]), config)
+	line.Fields = NewOrderedMap()
+
+	if config.timestampKeySet {
+		if micros, err := strconv.ParseInt(journalValue(entry["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+			line.Fields.Set(config.TimestampKey, time.UnixMicro(micros).UTC().Format(timeFormat))
+		}
+	}
+	if config.levelKeySet {
+		if priority, err := strconv.Atoi(journalValue(entry["PRIORITY"])); err == nil && priority >= 0 && priority < len(syslogSeverities) {
+			line.Fields.Set(config.LevelKey, syslogSeverities[priority])
+		}
+	}
+	for _, field := range journalFields {
+		if value := journalValue(entry[field[0]]); value != "" {
+			line.Fields.Set(field[1], value)
+		}
+	}
+	return line
+}
+
+// values are strings, or arrays of bytes when they are not valid utf-8
+func journalValue(raw json.RawMessage) string {
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value
+	}
+	var data []byte
+	var numbers []int
+	if err := json.Unmarshal(raw, &numbers); err == nil {
+		for _, number := range numbers {
+			data = append(data, byte(number))
+		}
+	}
+	return string(data)
+}
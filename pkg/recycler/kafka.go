@@ -0,0 +1,154 @@
+package recycler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Kafka produces logs as json in batches to a topic
+type Kafka struct {
+	Brokers     []string
+	Topic       string
+	Key         string // template like outputTemplate, for example {{.host}}
+	keyParsed   *template.Template
+	Compression string // gzip, snappy, lz4 or zstd
+	compression kafka.Compression
+	BatchSize   int           `yaml:"batchSize"`
+	BatchWait   time.Duration `yaml:"batchWait"`
+	Tls         *KafkaTls
+	tlsConfig   *tls.Config
+	Sasl        *KafkaSasl
+	mechanism   sasl.Mechanism
+	Retry       *Retry
+	Buffer      *DiskBuffer
+	batcher     *Batcher[kafka.Message]
+	writer      *kafka.Writer
+}
+
+type KafkaTls struct {
+	CaFile             string `yaml:"caFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+type KafkaSasl struct {
+	Mechanism string // plain, scram-sha-256 or scram-sha-512
+	Username  string
+	Password  string
+}
+
+var kafkaCompressions = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+func (k *Kafka) validate() error {
+	if len(k.Brokers) == 0 || k.Topic == "" {
+		return fmt.Errorf("kafka.brokers and kafka.topic must be set")
+	}
+	if err := validateBatchWait("kafka", k.BatchWait); err != nil {
+		return err
+	}
+
+	var err error
+	if k.keyParsed, err = parseFieldTemplate("kafka.key", k.Key); err != nil {
+		return err
+	}
+
+	if k.Compression != "" {
+		compression, found := kafkaCompressions[k.Compression]
+		if !found {
+			return fmt.Errorf("kafka.compression must be gzip, snappy, lz4 or zstd but was %v", k.Compression)
+		}
+		k.compression = compression
+	}
+
+	if k.Tls != nil {
+		k.tlsConfig = &tls.Config{InsecureSkipVerify: k.Tls.InsecureSkipVerify}
+		if k.Tls.CaFile != "" {
+			ca, err := ioutil.ReadFile(k.Tls.CaFile)
+			if err != nil {
+				return err
+			}
+			k.tlsConfig.RootCAs = x509.NewCertPool()
+			if !k.tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return fmt.Errorf("kafka.tls.caFile %v contains no certificates", k.Tls.CaFile)
+			}
+		}
+	}
+
+	if k.Sasl != nil {
+		switch k.Sasl.Mechanism {
+		case "plain":
+			k.mechanism = plain.Mechanism{Username: k.Sasl.Username, Password: k.Sasl.Password}
+		case "scram-sha-256":
+			k.mechanism, err = scram.Mechanism(scram.SHA256, k.Sasl.Username, k.Sasl.Password)
+		case "scram-sha-512":
+			k.mechanism, err = scram.Mechanism(scram.SHA512, k.Sasl.Username, k.Sasl.Password)
+		default:
+			return fmt.Errorf("kafka.sasl.mechanism must be plain, scram-sha-256 or scram-sha-512 but was %v", k.Sasl.Mechanism)
+		}
+		if err != nil {
+			return err // untested section
+		}
+	}
+	return nil
+}
+
+func (k *Kafka) Start() {
+	if k.BatchSize == 0 {
+		k.BatchSize = 100
+	}
+	if k.BatchWait == 0 {
+		k.BatchWait = time.Second
+	}
+	k.writer = &kafka.Writer{
+		Addr:         kafka.TCP(k.Brokers...),
+		Topic:        k.Topic,
+		Balancer:     &kafka.Hash{}, // same key goes to the same partition
+		BatchSize:    k.BatchSize,
+		BatchTimeout: 10 * time.Millisecond, // we already batched
+		MaxAttempts:  1,                     // we retry
+		Compression:  k.compression,
+		Transport:    &kafka.Transport{TLS: k.tlsConfig, SASL: k.mechanism},
+	}
+	k.batcher = NewBufferedBatcher(k.BatchSize, k.BatchWait, k.Buffer, k.send)
+}
+
+// Stop sends all remaining logs
+func (k *Kafka) Stop() {
+	k.batcher.Stop()
+	_ = k.writer.Close()
+}
+
+// Push a log, blocking when kafka cannot keep up
+func (k *Kafka) Push(log *OrderedMap) {
+	k.batcher.Add(k.message(log))
+}
+
+func (k *Kafka) message(log *OrderedMap) kafka.Message {
+	message := kafka.Message{Value: []byte(log.ToJson())}
+	if key := executeFieldTemplate(k.keyParsed, log); key != "" {
+		message.Key = []byte(key)
+	}
+	return message
+}
+
+func (k *Kafka) send(batch []kafka.Message) error {
+	return k.Retry.Send("producing to kafka", func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return true, k.writer.WriteMessages(ctx, batch...)
+	})
+}
@@ -0,0 +1,92 @@
+package recycler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kubernetes adds pod, namespace, node and selected pod labels from the downward api to every log
+// https://kubernetes.io/docs/concepts/workloads/pods/downward-api/
+type Kubernetes struct {
+	Labels       []string // pod labels to add
+	LabelsFile   string   `yaml:"labelsFile"`   // downward api volume file with metadata.labels (default /etc/podinfo/labels)
+	MetricLabels bool     `yaml:"metricLabels"` // also use the fields as metric labels
+	keys         []string
+	values       map[string]string
+}
+
+var kubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// read the metadata once, so a missing labels file fails at startup
+func (k *Kubernetes) load() error {
+	k.values = map[string]string{}
+
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod = os.Getenv("HOSTNAME") // pods use their name as hostname
+	}
+	k.add("pod", pod)
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		if content, err := ioutil.ReadFile(kubernetesNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(content))
+		}
+	}
+	k.add("namespace", namespace)
+	k.add("node", os.Getenv("NODE_NAME"))
+
+	if len(k.Labels) == 0 {
+		return nil
+	}
+	if k.LabelsFile == "" {
+		k.LabelsFile = "/etc/podinfo/labels"
+	}
+	labels, err := readDownwardApiLabels(k.LabelsFile)
+	if err != nil {
+		return fmt.Errorf("kubernetes.labelsFile: %v", err)
+	}
+	for _, label := range k.Labels {
+		k.add(label, labels[label])
+	}
+	return nil
+}
+
+// fields without a value are not added
+func (k *Kubernetes) add(key string, value string) {
+	if value == "" {
+		return
+	}
+	k.keys = append(k.keys, key)
+	k.values[key] = value
+}
+
+// Enrich the log with the metadata
+func (k *Kubernetes) Enrich(log *OrderedMap) {
+	for _, key := range k.keys {
+		log.Set(key, k.values[key])
+	}
+}
+
+// lines of key="value" with go style quoting
+func readDownwardApiLabels(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
@@ -0,0 +1,34 @@
+package recycler
+
+// KeyValues parses key=value pairs out of the message, accepts true or a list of keys to keep
+type KeyValues struct {
+	Enabled bool
+	Keys    []string // only these keys (default all)
+}
+
+func (k *KeyValues) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		*k = KeyValues{Enabled: enabled}
+		return nil
+	}
+
+	var keys []string
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	*k = KeyValues{Enabled: true, Keys: keys}
+	return nil
+}
+
+// Capture the pairs of the message as fields
+func (k *KeyValues) Capture(log *OrderedMap, message string) {
+	if !k.Enabled {
+		return
+	}
+	parseLogfmt(message, func(key string, value string) {
+		if k.Keys == nil || contains(k.Keys, key) {
+			log.Set(key, value)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package recycler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LevelKeywords guesses the level from words in the message, accepts true or a keyword -> level table
+type LevelKeywords struct {
+	Enabled bool
+	Table   map[string]string
+	regex   *regexp.Regexp
+}
+
+var defaultLevelKeywords = map[string]string{
+	"panic":      "FATAL",
+	"fatal":      "FATAL",
+	"error":      "ERROR",
+	"exception":  "ERROR",
+	"warn":       "WARN",
+	"warning":    "WARN",
+	"deprecated": "WARN",
+}
+
+func (k *LevelKeywords) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		*k = LevelKeywords{Enabled: enabled}
+		return nil
+	}
+
+	var table map[string]string
+	if err := unmarshal(&table); err != nil {
+		return err
+	}
+	*k = LevelKeywords{Enabled: true, Table: table}
+	return nil
+}
+
+// compile all keywords into one case-insensitive regex that only matches whole words
+func (k *LevelKeywords) compile() error {
+	if !k.Enabled {
+		return nil
+	}
+	if k.Table == nil {
+		k.Table = defaultLevelKeywords
+	}
+	if len(k.Table) == 0 {
+		return fmt.Errorf("levelKeywords must not be empty")
+	}
+
+	normalized := make(map[string]string, len(k.Table))
+	keywords := make([]string, 0, len(k.Table))
+	for keyword, level := range k.Table {
+		keyword = strings.ToLower(keyword)
+		normalized[keyword] = level
+		keywords = append(keywords, regexp.QuoteMeta(keyword))
+	}
+	sort.Strings(keywords) // stable regex for equal configs
+	k.Table = normalized
+	k.regex = regexp.MustCompile(`(?i)\b(?:` + strings.Join(keywords, "|") + `)\b`)
+	return nil
+}
+
+// Infer the most severe level of all keywords in the message
+func (k *LevelKeywords) Infer(message string) (string, bool) {
+	if k.regex == nil {
+		return "", false
+	}
+	level := ""
+	rank := -1
+	for _, keyword := range k.regex.FindAllString(message, -1) {
+		candidate := k.Table[strings.ToLower(keyword)]
+		if candidateRank := levelRanks[strings.ToUpper(candidate)]; candidateRank > rank {
+			level = candidate
+			rank = candidateRank
+		}
+	}
+	return level, rank != -1
+}
@@ -0,0 +1,294 @@
+package recycler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bodies of ingest requests, json arrays are read into memory at once
+var ingestMaxBytes int64 = 10 << 20
+
+// listener receives messages over the network for an Input with `listen`
+type listener struct {
+	packets     net.PacketConn // udp
+	stream      net.Listener   // tcp and http
+	server      *http.Server
+	stopped     chan struct{} // http server finished in-flight requests
+	lock        sync.Mutex
+	connections map[net.Conn]bool
+}
+
+// validate the listen and journald settings of an input
+func (i *Input) validate(location string) error {
+	sources := 0
+	for _, set := range []bool{i.Path != "", i.Listen != "", i.Journald} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("%v must set one of path, listen or journald", location)
+	}
+	if !i.Journald && (len(i.Units) != 0 || i.Priority != "") {
+		return fmt.Errorf("%v.units and %v.priority require journald", location, location)
+	}
+	if i.Priority != "" && !contains(journalPriorities, i.Priority) {
+		return fmt.Errorf("%v.priority must be one of %v but was %v", location, strings.Join(journalPriorities[:8], ", "), i.Priority)
+	}
+	if i.Journald && i.Follow {
+		return fmt.Errorf("%v.follow cannot be used with journald, it is always followed", location)
+	}
+	if i.Listen == "" {
+		if i.Protocol != "" {
+			return fmt.Errorf("%v.protocol requires listen", location)
+		}
+		return nil
+	}
+	if i.Follow {
+		return fmt.Errorf("%v.follow cannot be used with listen", location)
+	}
+	if i.Protocol == "" {
+		i.Protocol = "udp"
+	}
+	if i.Protocol != "udp" && i.Protocol != "tcp" && i.Protocol != "http" {
+		return fmt.Errorf("%v.protocol must be udp, tcp or http", location)
+	}
+	return nil
+}
+
+// listen on the configured address so a used port fails at startup
+func (i *Input) listen() error {
+	i.listener = &listener{connections: map[net.Conn]bool{}}
+	var err error
+	if i.Protocol == "http" {
+		// slow or stuck clients must not keep connections open forever
+		i.listener.server = &http.Server{ReadHeaderTimeout: 10 * time.Second, ReadTimeout: time.Minute}
+		i.listener.stopped = make(chan struct{})
+	}
+	if i.Protocol == "tcp" || i.Protocol == "http" {
+		i.listener.stream, err = net.Listen("tcp", i.Listen)
+	} else {
+		i.listener.packets, err = net.ListenPacket("udp", i.Listen)
+	}
+	return err
+}
+
+// address that is listened on, to find the port when listening on :0
+func (i *Input) address() string {
+	if i.listener.stream != nil {
+		return i.listener.stream.Addr().String()
+	}
+	return i.listener.packets.LocalAddr().String()
+}
+
+// stop listening and close open connections, messages that were already received are still processed
+func (l *listener) Stop() {
+	if l.server != nil {
+		// in-flight requests might be blocked on passing lines along, so do not wait for them here
+		go func() {
+			_ = l.server.Shutdown(context.Background())
+			close(l.stopped)
+		}()
+		return
+	}
+	if l.stream != nil {
+		_ = l.stream.Close()
+	} else {
+		_ = l.packets.Close()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for connection := range l.connections {
+		_ = connection.Close()
+	}
+}
+
+// receive messages until stopped, each udp packet is a message
+func (l *listener) ReadLines(lines chan<- Line, config *Config, protocol string) {
+	if protocol == "http" {
+		l.serve(lines, config)
+		return
+	}
+	if l.stream != nil {
+		l.accept(lines, config)
+		return
+	}
+
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := l.packets.ReadFrom(buffer)
+		if err != nil {
+			return // closed
+		}
+		for _, message := range strings.Split(strings.TrimRight(string(buffer[:n]), "\r\n"), "\n") {
+			lines <- receivedLine(message, config)
+		}
+	}
+}
+
+// accept tcp connections until stopped and read each one until it is closed
+func (l *listener) accept(lines chan<- Line, config *Config) {
+	var connections sync.WaitGroup
+	defer connections.Wait()
+	for {
+		connection, err := l.stream.Accept()
+		if err != nil {
+			return // closed
+		}
+
+		l.lock.Lock()
+		l.connections[connection] = true
+		l.lock.Unlock()
+
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			defer func() {
+				l.lock.Lock()
+				delete(l.connections, connection)
+				l.lock.Unlock()
+				_ = connection.Close()
+			}()
+			readSyslogStream(connection, lines, config)
+		}()
+	}
+}
+
+// serve POST /ingest until stopped, the request finishes after all its lines were passed along
+func (l *listener) serve(lines chan<- Line, config *Config) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body := &ingestBody{Reader: http.MaxBytesReader(w, r.Body, ingestMaxBytes)}
+		err := ingest(body, lines, config)
+		var tooLarge *http.MaxBytesError
+		if errors.As(body.err, &tooLarge) {
+			http.Error(w, body.err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	l.server.Handler = mux
+	_ = l.server.Serve(l.stream)
+	<-l.stopped
+}
+
+// ingestBody keeps the read error, since newline delimited bodies are read like other input that only reports it
+type ingestBody struct {
+	io.Reader
+	err error
+}
+
+func (b *ingestBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// pass along the lines of a newline delimited body or the elements of a json array,
+// objects are passed along as json so they can be parsed with `json: true`
+func ingest(body io.Reader, lines chan<- Line, config *Config) error {
+	buffered := bufio.NewReader(body)
+	for {
+		first, err := buffered.Peek(1)
+		if err != nil {
+			return nil // empty
+		}
+		if first[0] != ' ' && first[0] != '\n' && first[0] != '\r' && first[0] != '\t' {
+			break
+		}
+		_, _ = buffered.ReadByte()
+	}
+
+	if first, _ := buffered.Peek(1); first[0] != '[' {
+		readLines(buffered, "", lines, config)
+		return nil
+	}
+
+	var elements []json.RawMessage
+	if err := json.NewDecoder(buffered).Decode(&elements); err != nil {
+		return err
+	}
+	for _, element := range elements {
+		var text string
+		if err := json.Unmarshal(element, &text); err != nil {
+			var compacted bytes.Buffer
+			if err = json.Compact(&compacted, element); err != nil {
+				return err // untested section
+			}
+			text = compacted.String()
+		}
+		lines <- receivedLine(text, config)
+	}
+	return nil
+}
+
+// read messages from a tcp stream that are either newline delimited
+// or prefixed with their length https://datatracker.ietf.org/doc/html/rfc6587#section-3.4.1
+// newline delimited messages are read like other input, so a client that never sends a newline cannot use up memory
+func readSyslogStream(reader io.Reader, lines chan<- Line, config *Config) {
+	// + prefix of octet counted messages of the max length
+	prefixLength := len(strconv.Itoa(config.MaxLineLength)) + 1
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, min(4096, config.MaxLineLength+prefixLength)), config.MaxLineLength+prefixLength)
+	splitter := &lineSplitter{config: config}
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if splitter.skipping || len(data) == 0 || data[0] < '1' || data[0] > '9' {
+			return splitter.split(data, atEOF)
+		}
+
+		space := bytes.IndexByte(data, ' ')
+		if space == -1 && len(data) < prefixLength && !atEOF {
+			return 0, nil, nil // need more data
+		}
+		if space == -1 {
+			return 0, nil, fmt.Errorf("invalid length %q", data[:min(len(data), prefixLength)])
+		}
+		length, err := strconv.Atoi(string(data[:space]))
+		if err != nil || length > config.MaxLineLength {
+			return 0, nil, fmt.Errorf("invalid length %q", data[:space+1])
+		}
+		if len(data) < space+1+length {
+			return 0, nil, nil // need more data, incomplete messages at the end are dropped
+		}
+		return space + 1 + length, data[space+1 : space+1+length], nil
+	})
+
+	for scanner.Scan() {
+		if line := splitter.line(strings.TrimRight(scanner.Text(), "\r\n")); line.Text != "" {
+			lines <- line
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: reading syslog: %v\n", err.Error())
+	}
+}
+
+// a received message as a line, cut to the maximum line length
+func receivedLine(message string, config *Config) Line {
+	if len(message) > config.MaxLineLength {
+		return Line{Text: message[:config.MaxLineLength], Truncated: config.TruncateLongLines}
+	}
+	return Line{Text: message}
+}
@@ -0,0 +1,103 @@
+package recycler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Loki pushes logs in batches to https://grafana.com/docs/loki/latest/reference/api/#push-log-entries-to-loki
+type Loki struct {
+	Url       string
+	Labels    []string
+	BatchSize int           `yaml:"batchSize"`
+	BatchWait time.Duration `yaml:"batchWait"`
+	Retry     *Retry
+	Buffer    *DiskBuffer
+	batcher   *Batcher[lokiEntry]
+	client    *http.Client
+}
+
+// exported fields so it can be buffered on disk
+type lokiEntry struct {
+	Labels map[string]string `json:"labels"`
+	Time   time.Time         `json:"time"`
+	Line   string            `json:"line"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][]string        `json:"values"`
+}
+
+func (l *Loki) Start() {
+	if l.BatchSize == 0 {
+		l.BatchSize = 100
+	}
+	if l.BatchWait == 0 {
+		l.BatchWait = time.Second
+	}
+	l.client = &http.Client{Timeout: 10 * time.Second}
+	l.batcher = NewBufferedBatcher(l.BatchSize, l.BatchWait, l.Buffer, l.send)
+}
+
+// Stop sends all remaining logs
+func (l *Loki) Stop() {
+	l.batcher.Stop()
+}
+
+// Push a formatted log line, using the configured fields as stream labels
+func (l *Loki) Push(log *OrderedMap, line string) {
+	labels := make(map[string]string, len(l.Labels))
+	for _, label := range l.Labels {
+		if value, found := log.values[label]; found {
+			labels[label] = value
+		}
+	}
+	l.batcher.Add(lokiEntry{Labels: labels, Time: time.Now(), Line: line})
+}
+
+func (l *Loki) send(batch []lokiEntry) error {
+	// group entries by their labels
+	streams := []*lokiStream{}
+	grouped := map[string]*lokiStream{}
+	for _, entry := range batch {
+		key := labelsKey(entry.Labels)
+		stream, found := grouped[key]
+		if !found {
+			stream = &lokiStream{Stream: entry.Labels, Values: [][]string{}}
+			grouped[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, []string{strconv.FormatInt(entry.Time.UnixNano(), 10), entry.Line})
+	}
+
+	body, err := json.Marshal(map[string][]*lokiStream{"streams": streams})
+	if err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: building loki request: %v\n", err.Error())
+		return nil // sending again would fail the same way
+	}
+
+	return l.Retry.Send("pushing to loki", func() (bool, error) { return l.post(body) })
+}
+
+// returns if the request should be retried
+func (l *Loki) post(body []byte) (bool, error) {
+	response, err := l.client.Post(l.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode == 429 || response.StatusCode >= 500 {
+		return true, fmt.Errorf("status %v", response.StatusCode)
+	}
+	if response.StatusCode >= 300 {
+		return false, fmt.Errorf("status %v", response.StatusCode)
+	}
+	return false, nil
+}
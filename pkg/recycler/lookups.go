@@ -0,0 +1,60 @@
+package recycler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+// Lookup maps a field through a table, for example status code -> class or service id -> team
+type Lookup struct {
+	Field   string            // field to look up
+	To      string            // field to set with the result
+	Table   map[string]string // value -> result
+	File    string            // csv file with value,result rows, merged into table
+	Default *string           // result when the value is not in the table (default not set)
+}
+
+func (l *Lookup) validate(location string) error {
+	if l.Field == "" || l.To == "" {
+		return fmt.Errorf("%v.field and %v.to must be set", location, location)
+	}
+	if l.Table == nil && l.File == "" {
+		return fmt.Errorf("%v must set one of table or file", location)
+	}
+	if l.File == "" {
+		return nil
+	}
+
+	file, err := os.Open(l.File)
+	if err != nil {
+		return fmt.Errorf("%v.file: %v", location, err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("%v.file: %v", location, err)
+	}
+	if l.Table == nil {
+		l.Table = map[string]string{}
+	}
+	for _, row := range rows {
+		l.Table[row[0]] = row[1]
+	}
+	return nil
+}
+
+// Apply sets the result when the log has the field
+func (l *Lookup) Apply(log *OrderedMap) {
+	value, found := log.values[l.Field]
+	if !found {
+		return
+	}
+	if result, found := l.Table[value]; found {
+		log.Set(l.To, result)
+	} else if l.Default != nil {
+		log.Set(l.To, *l.Default)
+	}
+}
@@ -0,0 +1,132 @@
+package recycler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	luaparse "github.com/yuin/gopher-lua/parse"
+)
+
+// LuaScript transforms logs in ways regexes cannot express, like arithmetic on captures or conditional fields,
+// the script changes the global `log` table, setting a field to nil removes it and returning false drops the log
+type LuaScript struct {
+	location string
+	proto    *lua.FunctionProto
+	states   sync.Pool // lua states are not safe for concurrent use
+}
+
+// libraries available to scripts, no io or os so scripts cannot touch the system
+var luaLibraries = map[string]lua.LGFunction{
+	lua.BaseLibName:   lua.OpenBase,
+	lua.TabLibName:    lua.OpenTable,
+	lua.StringLibName: lua.OpenString,
+	lua.MathLibName:   lua.OpenMath,
+}
+
+// compile once, nil when there is no script
+func compileLua(source string, location string) (*LuaScript, error) {
+	if source == "" {
+		return nil, nil
+	}
+	chunk, err := luaparse.Parse(strings.NewReader(source), location)
+	if err != nil {
+		return nil, errors.New(strings.TrimSpace(err.Error())) // already includes the location
+	}
+	proto, err := lua.Compile(chunk, location)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", location, err) // untested section
+	}
+	script := &LuaScript{location: location, proto: proto}
+	script.states.New = func() interface{} {
+		state := lua.NewState(lua.Options{SkipOpenLibs: true})
+		for name, open := range luaLibraries {
+			state.Push(state.NewFunction(open))
+			state.Push(lua.LString(name))
+			state.Call(1, 0)
+		}
+		return state
+	}
+	return script, nil
+}
+
+// Apply runs the script with the log, false when it should be dropped, the log is kept as is when the script fails
+func (s *LuaScript) Apply(log *OrderedMap) bool {
+	state := s.states.Get().(*lua.LState)
+	defer s.states.Put(state)
+
+	table := state.NewTable()
+	for _, key := range log.keys {
+		table.RawSetString(key, luaValue(log, key))
+	}
+	state.SetGlobal("log", table)
+	state.Push(state.NewFunctionFromProto(s.proto))
+	if err := state.PCall(0, 1, nil); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v: %v\n", s.location, err.Error())
+		return true
+	}
+	result := state.Get(-1)
+	state.Pop(1)
+
+	// removed fields, then changed fields in their order and new fields sorted
+	for _, key := range append([]string(nil), log.keys...) {
+		if table.RawGetString(key) == lua.LNil {
+			log.Delete(key)
+		}
+	}
+	var added []string
+	table.ForEach(func(key lua.LValue, value lua.LValue) {
+		if key, ok := key.(lua.LString); ok {
+			if _, found := log.values[string(key)]; !found {
+				added = append(added, string(key))
+			}
+		}
+	})
+	sort.Strings(added)
+	for _, key := range append(append([]string(nil), log.keys...), added...) {
+		setLuaValue(log, key, table.RawGetString(key))
+	}
+	return result != lua.LFalse
+}
+
+// typed values keep their type, everything else is a string that lua converts for arithmetic
+func luaValue(log *OrderedMap, key string) lua.LValue {
+	value := log.values[key]
+	switch log.types[key] {
+	case "int", "float":
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return lua.LNumber(parsed)
+		}
+	case "bool":
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return lua.LBool(parsed)
+		}
+	}
+	return lua.LString(value)
+}
+
+func setLuaValue(log *OrderedMap, key string, value lua.LValue) {
+	switch value := value.(type) {
+	case lua.LNumber:
+		number := float64(value)
+		if number == math.Trunc(number) && math.Abs(number) < 1e15 {
+			log.Set(key, strconv.FormatInt(int64(number), 10))
+			log.SetType(key, "int")
+		} else {
+			log.Set(key, strconv.FormatFloat(number, 'f', -1, 64))
+			log.SetType(key, "float")
+		}
+	case lua.LBool:
+		log.Set(key, strconv.FormatBool(bool(value)))
+		log.SetType(key, "bool")
+	default:
+		log.Set(key, value.String())
+		delete(log.types, key)
+	}
+}
@@ -0,0 +1,72 @@
+package recycler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metric observes a numeric field, for example a captured duration
+type Metric struct {
+	Name    string
+	Help    string
+	Type    string // histogram or summary
+	Field   string
+	Labels  []string
+	Buckets []float64 // histogram buckets, prometheus defaults when empty
+}
+
+func (m *Metric) validate(location string) error {
+	if !metricNameRegex.MatchString(m.Name) {
+		return fmt.Errorf("%v.name must match %v but was %v", location, metricNameRegex, m.Name)
+	}
+	if strings.HasPrefix(m.Name, "logrecycler_") {
+		return fmt.Errorf("%v.name must not start with logrecycler_ since those metrics are reported by logrecycler but was %v", location, m.Name)
+	}
+	if m.Field == "" {
+		return fmt.Errorf("%v.field must be set", location)
+	}
+	switch m.Type {
+	case "", "histogram":
+		m.Type = "histogram"
+	case "summary":
+	default:
+		return fmt.Errorf("%v.type must be histogram or summary but was %v", location, m.Type)
+	}
+	// prometheus adds a label for the bucket or quantile
+	reserved := "le"
+	if m.Type == "summary" {
+		reserved = "quantile"
+	}
+	if contains(prometheusLabelNames(m.Labels), reserved) {
+		return fmt.Errorf("%v.labels must not contain %v since it is used by the %v", location, reserved, m.Type)
+	}
+	for i := 1; i < len(m.Buckets); i++ {
+		if m.Buckets[i] <= m.Buckets[i-1] {
+			return fmt.Errorf("%v.buckets must be in increasing order but were %v", location, m.Buckets)
+		}
+	}
+	if m.Help == "" {
+		m.Help = "Observed " + m.Field
+	}
+	return nil
+}
+
+// value of the field, false when missing or not a number
+func (m *Metric) value(values map[string]string) (float64, bool) {
+	raw, found := values[m.Field]
+	if !found {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	return value, err == nil
+}
+
+// label values in the configured order, missing labels are empty
+func (m *Metric) labelValues(values map[string]string) []string {
+	labelValues := make([]string, len(m.Labels))
+	for i, label := range m.Labels {
+		labelValues[i] = values[label]
+	}
+	return labelValues
+}
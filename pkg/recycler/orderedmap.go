@@ -0,0 +1,398 @@
+package recycler
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// minimal & fast ordered map implementation since go does not offer it
+type OrderedMap struct {
+	keys   []string
+	values map[string]string
+	types  map[string]string // json type of values that are not strings, allocated when needed
+	nested bool              // ToJson renders keys like http.method as {"http":{"method":...}}
+	raw    *string           // output instead of the fields, for unmatched lines that pass through
+}
+
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{keys: []string{}, values: map[string]string{}}
+}
+
+// maps and labels are reused between lines to reduce allocations and gc pressure on high volume streams
+var orderedMapPool = sync.Pool{New: func() interface{} { return NewOrderedMap() }}
+var labelsPool = sync.Pool{New: func() interface{} { return map[string]string{} }}
+
+// an empty map from the pool, release it once it was output
+func acquireOrderedMap() *OrderedMap {
+	return orderedMapPool.Get().(*OrderedMap)
+}
+
+// reset the map and put it back into the pool, it must not be used afterwards
+func releaseOrderedMap(m *OrderedMap) {
+	m.keys = m.keys[:0]
+	clear(m.values)
+	m.types = nil
+	m.nested = false
+	m.raw = nil
+	orderedMapPool.Put(m)
+}
+
+func acquireLabels() map[string]string {
+	return labelsPool.Get().(map[string]string)
+}
+
+func releaseLabels(labels map[string]string) {
+	clear(labels)
+	labelsPool.Put(labels)
+}
+
+func (m *OrderedMap) Set(key string, value string) {
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Merge in key order so output does not depend on map iteration order
+func (m *OrderedMap) Merge(add map[string]string) {
+	names := keys(add)
+	sort.Strings(names)
+	for _, k := range names {
+		m.Set(k, add[k])
+	}
+}
+
+// SetType makes ToJson render the value as int, float or bool when it can be parsed as one
+func (m *OrderedMap) SetType(key string, kind string) {
+	if m.types == nil {
+		m.types = map[string]string{}
+	}
+	m.types[key] = kind
+}
+
+// Rename a key, keeping its position and overwriting the new key if it exists
+func (m *OrderedMap) Rename(from string, to string) {
+	value, exists := m.values[from]
+	if !exists || from == to {
+		return
+	}
+	m.Delete(to)
+	m.values[to] = value
+	delete(m.values, from)
+	if kind, found := m.types[from]; found {
+		m.types[to] = kind
+		delete(m.types, from)
+	}
+	for i, key := range m.keys {
+		if key == from {
+			m.keys[i] = to
+			break
+		}
+	}
+}
+
+func (m *OrderedMap) Delete(key string) {
+	if _, exists := m.values[key]; !exists {
+		return
+	}
+	delete(m.values, key)
+	delete(m.types, key)
+	m.keys = removeElement(m.keys, key)
+}
+
+// Select keeps only the fields in the given order, others are dropped or nested under the extra key
+func (m *OrderedMap) Select(fields []string, selected map[string]bool, extraKey string) {
+	var extra []string
+	for _, key := range m.keys {
+		if !selected[key] {
+			extra = append(extra, key)
+		}
+	}
+
+	m.keys = m.keys[:0] // only overwrites keys that were already copied
+	for _, field := range fields {
+		if _, found := m.values[field]; found {
+			m.keys = append(m.keys, field)
+		}
+	}
+
+	for _, key := range extra {
+		if extraKey == "" {
+			delete(m.values, key)
+			delete(m.types, key)
+			continue
+		}
+		to := extraKey + "." + key
+		m.values[to] = m.values[key]
+		delete(m.values, key)
+		if kind, found := m.types[key]; found {
+			m.types[to] = kind
+			delete(m.types, key)
+		}
+		m.keys = append(m.keys, to)
+		m.nested = true
+	}
+}
+
+// more efficient than creating a new map and merging it
+func (m *OrderedMap) StoreNamedCaptures(re *regexp.Regexp, match *[]string) {
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			m.Set(name, (*match)[i])
+		}
+	}
+}
+
+// StoreCaptures sets each submatch that has a name, names are aligned with the submatches
+func (m *OrderedMap) StoreCaptures(names []string, match []string) {
+	for i, name := range names {
+		if name != "" {
+			m.Set(name, match[i])
+		}
+	}
+}
+
+// go says ordering json is obviously wrong so we do it ourselves to keep things like level/timestamp first
+// to make the logs human-readable
+// https://github.com/golang/go/issues/27179
+// https://stackoverflow.com/questions/25182923/serialize-a-map-using-a-specific-order
+func (m *OrderedMap) ToJson() string {
+	buffer := jsonBufferPool.Get().(*[]byte)
+	*buffer = m.AppendJson((*buffer)[:0])
+	encoded := string(*buffer)
+	jsonBufferPool.Put(buffer)
+	return encoded
+}
+
+// encoding buffers are reused so only the final string is allocated
+var jsonBufferPool = sync.Pool{New: func() interface{} { buffer := make([]byte, 0, 1024); return &buffer }}
+
+// AppendJson writes the json object to the buffer and returns the extended buffer
+func (m *OrderedMap) AppendJson(buffer []byte) []byte {
+	if m.nested {
+		return m.appendNestedJson(buffer)
+	}
+	buffer = append(buffer, '{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buffer = append(buffer, ',')
+		}
+		buffer = appendJsonString(buffer, key)
+		buffer = append(buffer, ':')
+		buffer = m.appendTyped(buffer, key)
+	}
+	return append(buffer, '}')
+}
+
+// object in the order their first key was set, keys that conflict with a value stay flat
+type jsonNode struct {
+	keys     []string
+	children map[string]*jsonNode
+	leaves   map[string]string // key -> original key when it is a value
+}
+
+func newJsonNode() *jsonNode {
+	return &jsonNode{children: map[string]*jsonNode{}, leaves: map[string]string{}}
+}
+
+func (m *OrderedMap) appendNestedJson(buffer []byte) []byte {
+	root := newJsonNode()
+	for _, key := range m.keys {
+		node := root
+		parts := strings.Split(key, ".")
+		for i, part := range parts[:len(parts)-1] {
+			if _, isLeaf := node.leaves[part]; isLeaf {
+				parts = append(parts[:i], strings.Join(parts[i:], ".")) // conflicts with a value
+				break
+			}
+			child, found := node.children[part]
+			if !found {
+				child = newJsonNode()
+				node.children[part] = child
+				node.keys = append(node.keys, part)
+			}
+			node = child
+		}
+		last := parts[len(parts)-1]
+		if child, found := node.children[last]; found {
+			// conflicts with an object, keep it flat or inside the object when it has no dots
+			if strings.Contains(key, ".") {
+				node, last = root, key
+			} else {
+				node, last = child, ""
+			}
+		}
+		if _, found := node.leaves[last]; !found {
+			node.keys = append(node.keys, last)
+		}
+		node.leaves[last] = key
+	}
+	return m.appendNode(buffer, root)
+}
+
+func (m *OrderedMap) appendNode(buffer []byte, node *jsonNode) []byte {
+	buffer = append(buffer, '{')
+	for i, key := range node.keys {
+		if i > 0 {
+			buffer = append(buffer, ',')
+		}
+		buffer = appendJsonString(buffer, key)
+		buffer = append(buffer, ':')
+		if original, isLeaf := node.leaves[key]; isLeaf {
+			buffer = m.appendTyped(buffer, original)
+		} else {
+			buffer = m.appendNode(buffer, node.children[key])
+		}
+	}
+	return append(buffer, '}')
+}
+
+// typed values that do not parse stay strings so no data is lost
+func (m *OrderedMap) appendTyped(buffer []byte, key string) []byte {
+	value := m.values[key]
+	switch m.types[key] {
+	case "int":
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return strconv.AppendInt(buffer, parsed, 10)
+		}
+	case "float":
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(parsed, 0) && !math.IsNaN(parsed) {
+			return strconv.AppendFloat(buffer, parsed, 'f', -1, 64)
+		}
+	case "bool":
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return strconv.AppendBool(buffer, parsed)
+		}
+	}
+	return appendJsonString(buffer, value)
+}
+
+// quoted and escaped json string
+func jsonString(value string) string {
+	return string(appendJsonString(nil, value))
+}
+
+const hex = "0123456789abcdef"
+
+// escapes like json.Marshal does, including html characters and invalid utf8, without allocating
+func appendJsonString(buffer []byte, value string) []byte {
+	buffer = append(buffer, '"')
+	start := 0
+	for i := 0; i < len(value); {
+		if b := value[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buffer = append(buffer, value[start:i]...)
+			switch b {
+			case '"', '\\':
+				buffer = append(buffer, '\\', b)
+			case '\b':
+				buffer = append(buffer, '\\', 'b')
+			case '\f':
+				buffer = append(buffer, '\\', 'f')
+			case '\n':
+				buffer = append(buffer, '\\', 'n')
+			case '\r':
+				buffer = append(buffer, '\\', 'r')
+			case '\t':
+				buffer = append(buffer, '\\', 't')
+			default:
+				buffer = append(buffer, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			buffer = append(buffer, value[start:i]...)
+			buffer = utf8.AppendRune(buffer, utf8.RuneError)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' { // line separators break javascript
+			buffer = append(buffer, value[start:i]...)
+			buffer = append(buffer, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buffer = append(buffer, value[start:]...)
+	return append(buffer, '"')
+}
+
+// key=value pairs in key order, quoting values that would otherwise be ambiguous
+// https://brandur.org/logfmt
+func (m *OrderedMap) ToLogfmt() string {
+	items := make([]string, len(m.keys))
+	for i, key := range m.keys {
+		items[i] = key + "=" + logfmtValue(m.values[key])
+	}
+	return strings.Join(items, " ")
+}
+
+// call fn for each key=value pair, values can be quoted, words without = are skipped
+func parseLogfmt(text string, fn func(key string, value string)) {
+	for i := 0; i < len(text); {
+		if text[i] == ' ' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(text) && text[i] != '=' && text[i] != ' ' {
+			i++
+		}
+		if i == start {
+			i++ // stray =
+			continue
+		}
+		if i == len(text) || text[i] == ' ' {
+			continue // not a pair
+		}
+		key := text[start:i]
+		i++ // =
+
+		if i < len(text) && text[i] == '"' {
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				end = len(text) - 1 // unterminated, take the rest
+			}
+			quoted := text[i : end+1]
+			if value, err := strconv.Unquote(quoted); err == nil {
+				fn(key, value)
+			} else {
+				fn(key, strings.Trim(quoted, `"`))
+			}
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(text) && text[i] != ' ' {
+			i++
+		}
+		fn(key, text[start:i])
+	}
+}
+
+func logfmtValue(value string) string {
+	if strings.ContainsAny(value, " =\"\\") || strings.IndexFunc(value, func(r rune) bool { return r < ' ' }) != -1 {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,218 @@
+package recycler
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// OtlpConnection is how logs and metrics are exported to an opentelemetry collector https://opentelemetry.io/docs/specs/otlp/
+type OtlpConnection struct {
+	Endpoint string            // http://collector:4318/v1/logs (or /v1/metrics) or collector:4317 for grpc
+	Protocol string            // http or grpc
+	Insecure bool              // grpc without tls
+	Headers  map[string]string // for example authorization
+	Resource map[string]string // resource attributes like service.name
+	resource *resourcepb.Resource
+	client   *http.Client
+	conn     *grpc.ClientConn
+}
+
+// Otlp exports logs in batches
+type Otlp struct {
+	OtlpConnection `yaml:",inline"`
+	BatchSize      int           `yaml:"batchSize"`
+	BatchWait      time.Duration `yaml:"batchWait"`
+	Retry          *Retry
+	timestampKey   string
+	levelKey       string
+	messageKey     string
+	batcher        *Batcher[*logspb.LogRecord]
+}
+
+func (c *OtlpConnection) validate(location string) error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("%v.endpoint must be set", location)
+	}
+	switch c.Protocol {
+	case "":
+		c.Protocol = "http"
+	case "http", "grpc":
+	default:
+		return fmt.Errorf("%v.protocol must be http or grpc but was %v", location, c.Protocol)
+	}
+
+	c.resource = &resourcepb.Resource{Attributes: otlpAttributes(c.Resource, sortedMapKeys(c.Resource))}
+	return nil
+}
+
+func (c *OtlpConnection) connect() error {
+	if c.Protocol == "grpc" {
+		credential := credentials.NewTLS(&tls.Config{})
+		if c.Insecure {
+			credential = insecure.NewCredentials()
+		}
+		var err error
+		c.conn, err = grpc.NewClient(c.Endpoint, grpc.WithTransportCredentials(credential))
+		return err
+	}
+	c.client = &http.Client{Timeout: 10 * time.Second}
+	return nil
+}
+
+func (c *OtlpConnection) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+// export the request as protobuf over http or with the given grpc call
+func (c *OtlpConnection) export(request proto.Message, call func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	if c.conn == nil {
+		return c.exportHttp(request)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if len(c.Headers) != 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(c.Headers))
+	}
+	return call(ctx, c.conn)
+}
+
+func (o *Otlp) validate(config *Config) error {
+	if err := o.OtlpConnection.validate("otlp"); err != nil {
+		return err
+	}
+	if err := validateBatchWait("otlp", o.BatchWait); err != nil {
+		return err
+	}
+
+	// fields that are part of the log data model and not attributes
+	o.timestampKey = config.TimestampKey
+	o.levelKey = config.LevelKey
+	o.messageKey = config.MessageKey
+	return nil
+}
+
+func (o *Otlp) Start() error {
+	if o.BatchSize == 0 {
+		o.BatchSize = 100
+	}
+	if o.BatchWait == 0 {
+		o.BatchWait = time.Second
+	}
+	if err := o.connect(); err != nil {
+		return err
+	}
+	o.batcher = NewBufferedBatcher(o.BatchSize, o.BatchWait, nil, o.send)
+	return nil
+}
+
+// Stop sends all remaining logs
+func (o *Otlp) Stop() {
+	o.batcher.Stop()
+	o.close()
+}
+
+// Push a log, blocking when the collector cannot keep up
+func (o *Otlp) Push(log *OrderedMap) {
+	o.batcher.Add(o.record(log, time.Now()))
+}
+
+// map to the log data model, other fields become attributes
+func (o *Otlp) record(log *OrderedMap, now time.Time) *logspb.LogRecord {
+	record := &logspb.LogRecord{
+		TimeUnixNano:         uint64(now.UnixNano()),
+		ObservedTimeUnixNano: uint64(now.UnixNano()),
+		Body:                 otlpString(log.values[o.messageKey]),
+	}
+	if timestamp, found := log.values[o.timestampKey]; found && o.timestampKey != "" {
+		if parsed, err := time.Parse(timeFormat, timestamp); err == nil {
+			record.TimeUnixNano = uint64(parsed.UnixNano())
+		}
+	}
+	if level, found := log.values[o.levelKey]; found && o.levelKey != "" {
+		record.SeverityText = level
+		if rank, found := levelRanks[strings.ToUpper(level)]; found {
+			record.SeverityNumber = logspb.SeverityNumber((rank-1)*4 + 1)
+		}
+	}
+
+	attributes := []string{}
+	for _, key := range log.keys {
+		if key != o.messageKey && key != o.timestampKey && key != o.levelKey {
+			attributes = append(attributes, key)
+		}
+	}
+	record.Attributes = otlpAttributes(log.values, attributes)
+	return record
+}
+
+func (o *Otlp) send(batch []*logspb.LogRecord) error {
+	request := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource:  o.resource,
+			ScopeLogs: []*logspb.ScopeLogs{{Scope: &commonpb.InstrumentationScope{Name: "logrecycler", Version: Version}, LogRecords: batch}},
+		}},
+	}
+
+	return o.Retry.Send("exporting to otlp", func() (bool, error) {
+		return true, o.export(request, func(ctx context.Context, conn *grpc.ClientConn) error {
+			_, err := collogspb.NewLogsServiceClient(conn).Export(ctx, request)
+			return err
+		})
+	})
+}
+
+func (c *OtlpConnection) exportHttp(request proto.Message) error {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err // untested section
+	}
+	httpRequest, err := http.NewRequest("POST", c.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err // untested section
+	}
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	for key, value := range c.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	response, err := c.client.Do(httpRequest)
+	if err != nil {
+		return err // untested section
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("status %v: %v", response.StatusCode, string(content))
+	}
+	return nil
+}
+
+func otlpString(value string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+}
+
+func otlpAttributes(values map[string]string, keys []string) []*commonpb.KeyValue {
+	attributes := make([]*commonpb.KeyValue, len(keys))
+	for i, key := range keys {
+		attributes[i] = &commonpb.KeyValue{Key: key, Value: otlpString(values[key])}
+	}
+	return attributes
+}
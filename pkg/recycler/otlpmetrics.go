@@ -0,0 +1,205 @@
+package recycler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+)
+
+// OtlpMetrics periodically exports the same counter and histograms as prometheus
+type OtlpMetrics struct {
+	OtlpConnection `yaml:",inline"`
+	Metric         string        // name of the counter (default logs_total)
+	Interval       time.Duration // how often to export (default 10s)
+	metrics        []Metric
+	start          time.Time
+	lock           sync.Mutex
+	counts         map[string]*otlpCount
+	histograms     []map[string]*otlpHistogram
+	stop           chan struct{}
+	done           sync.WaitGroup
+}
+
+type otlpCount struct {
+	attributes []*commonpb.KeyValue
+	value      uint64
+}
+
+type otlpHistogram struct {
+	attributes []*commonpb.KeyValue
+	count      uint64
+	sum        float64
+	buckets    []uint64
+}
+
+func (o *OtlpMetrics) validate(metrics []Metric) error {
+	if err := o.OtlpConnection.validate("otlpMetrics"); err != nil {
+		return err
+	}
+	if o.Metric == "" {
+		o.Metric = "logs_total"
+	}
+	if o.Interval == 0 {
+		o.Interval = 10 * time.Second
+	}
+	o.metrics = metrics
+	for i := range o.metrics {
+		if len(o.metrics[i].Buckets) == 0 {
+			o.metrics[i].Buckets = prometheus.DefBuckets
+		}
+	}
+	return nil
+}
+
+func (o *OtlpMetrics) Start() error {
+	o.start = time.Now()
+	o.counts = map[string]*otlpCount{}
+	o.histograms = make([]map[string]*otlpHistogram, len(o.metrics))
+	for i := range o.histograms {
+		o.histograms[i] = map[string]*otlpHistogram{}
+	}
+	if err := o.connect(); err != nil {
+		return err
+	}
+
+	o.stop = make(chan struct{})
+	o.done.Add(1)
+	go func() {
+		defer o.done.Done()
+		ticker := time.NewTicker(o.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				o.send()
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop exports the final values
+func (o *OtlpMetrics) Stop() {
+	close(o.stop)
+	o.done.Wait()
+	o.send()
+	o.close()
+}
+
+func (o *OtlpMetrics) Inc(labels map[string]string) {
+	key := labelsKey(labels)
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	count, found := o.counts[key]
+	if !found {
+		count = &otlpCount{attributes: otlpAttributes(labels, sortedMapKeys(labels))}
+		o.counts[key] = count
+	}
+	count.value++
+}
+
+// Observe all configured metrics that have a numeric value
+func (o *OtlpMetrics) Observe(values map[string]string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for i, metric := range o.metrics {
+		value, ok := metric.value(values)
+		if !ok {
+			continue
+		}
+
+		labels := make(map[string]string, len(metric.Labels))
+		for j, label := range metric.labelValues(values) {
+			labels[metric.Labels[j]] = label
+		}
+		key := labelsKey(labels)
+		histogram, found := o.histograms[i][key]
+		if !found {
+			histogram = &otlpHistogram{attributes: otlpAttributes(labels, metric.Labels), buckets: make([]uint64, len(metric.Buckets)+1)}
+			o.histograms[i][key] = histogram
+		}
+		histogram.count++
+		histogram.sum += value
+		histogram.buckets[sort.SearchFloat64s(metric.Buckets, value)]++
+	}
+}
+
+// cumulative values since start
+func (o *OtlpMetrics) request(now time.Time) *colmetricspb.ExportMetricsServiceRequest {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	start := uint64(o.start.UnixNano())
+	end := uint64(now.UnixNano())
+
+	counter := &metricspb.Sum{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, IsMonotonic: true}
+	for _, key := range sortedMapKeys(o.counts) {
+		count := o.counts[key]
+		counter.DataPoints = append(counter.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        count.attributes,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      end,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(count.value)},
+		})
+	}
+	metrics := []*metricspb.Metric{{Name: o.Metric, Description: "Total number of logs received", Data: &metricspb.Metric_Sum{Sum: counter}}}
+
+	for i, metric := range o.metrics {
+		histogram := &metricspb.Histogram{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+		for _, key := range sortedMapKeys(o.histograms[i]) {
+			observed := o.histograms[i][key]
+			sum := observed.sum
+			histogram.DataPoints = append(histogram.DataPoints, &metricspb.HistogramDataPoint{
+				Attributes:        observed.attributes,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				Count:             observed.count,
+				Sum:               &sum,
+				BucketCounts:      append([]uint64{}, observed.buckets...),
+				ExplicitBounds:    metric.Buckets,
+			})
+		}
+		metrics = append(metrics, &metricspb.Metric{Name: metric.Name, Description: metric.Help, Data: &metricspb.Metric_Histogram{Histogram: histogram}})
+	}
+
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource:     o.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Scope: &commonpb.InstrumentationScope{Name: "logrecycler", Version: Version}, Metrics: metrics}},
+		}},
+	}
+}
+
+func (o *OtlpMetrics) send() {
+	request := o.request(time.Now())
+	err := o.export(request, func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := colmetricspb.NewMetricsServiceClient(conn).Export(ctx, request)
+		return err
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: exporting metrics to otlp: %v\n", err.Error())
+	}
+}
+
+func sortedMapKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package recycler
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// Output writes lines, optionally buffering them and flushing periodically to avoid a syscall per line
+type Output struct {
+	lock   sync.Mutex
+	writer io.Writer
+	buffer *bufio.Writer
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+func NewOutput(writer io.Writer, bufferSize int, flushInterval time.Duration) *Output {
+	o := &Output{writer: writer}
+	if bufferSize == 0 {
+		return o
+	}
+
+	o.buffer = bufio.NewWriterSize(writer, bufferSize)
+	o.writer = o.buffer
+	o.stop = make(chan struct{})
+	o.done.Add(1)
+	go func() {
+		defer o.done.Done()
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				o.Flush()
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+	return o
+}
+
+// WriteLine writes the line with a trailing newline
+func (o *Output) WriteLine(line string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	_, _ = io.WriteString(o.writer, line+"\n")
+}
+
+func (o *Output) Flush() {
+	if o.buffer == nil {
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	_ = o.buffer.Flush()
+}
+
+// Stop flushing periodically and flush what is left
+func (o *Output) Stop() {
+	if o.stop != nil {
+		close(o.stop)
+		o.done.Wait()
+	}
+	o.Flush()
+}
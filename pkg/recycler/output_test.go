@@ -0,0 +1,64 @@
+package recycler
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// bytes.Buffer that can be read while being written to
+type lockedBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+var _ = Describe("Output", func() {
+	It("writes immediately without buffer", func() {
+		written := &lockedBuffer{}
+		output := NewOutput(written, 0, 0)
+		output.WriteLine("hi")
+		Expect(written.String()).To(Equal("hi\n"))
+		output.Stop()
+	})
+
+	It("flushes periodically", func() {
+		written := &lockedBuffer{}
+		output := NewOutput(written, 1024, time.Millisecond)
+		defer output.Stop()
+		output.WriteLine("hi")
+		Expect(written.String()).To(Equal(""))
+		Eventually(written.String).Should(Equal("hi\n"))
+	})
+
+	It("flushes when full", func() {
+		written := &lockedBuffer{}
+		output := NewOutput(written, 3, time.Hour)
+		defer output.Stop()
+		output.WriteLine("hi")
+		output.WriteLine("ho")
+		Expect(written.String()).To(Equal("hi\n"))
+	})
+
+	It("flushes on stop", func() {
+		written := &lockedBuffer{}
+		output := NewOutput(written, 1024, time.Hour)
+		output.WriteLine("hi")
+		output.Stop()
+		Expect(written.String()).To(Equal("hi\n"))
+	})
+})
@@ -0,0 +1,158 @@
+package recycler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutputFile writes formatted lines to a file and rotates it by size or age
+type OutputFile struct {
+	Path     string
+	MaxSize  int64         `yaml:"maxSize"` // bytes, rotate before the file gets bigger
+	MaxAge   time.Duration `yaml:"maxAge"`  // rotate files that were opened this long ago
+	Gzip     bool          // compress rotated files
+	Retain   int           // number of rotated files to keep, all when 0
+	lock     sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	rotated  chan string // rotated files are compressed and cleaned up in order
+	done     chan struct{}
+}
+
+// format of the suffix of rotated files, sorts by time
+const outputFileTimeFormat = "20060102T150405.000000000"
+
+func (o *OutputFile) Start() error {
+	o.rotated = make(chan string, 10)
+	o.done = make(chan struct{})
+	go func() {
+		defer close(o.done)
+		for path := range o.rotated {
+			o.cleanup(path)
+		}
+	}()
+	return o.open()
+}
+
+// Stop closes the file after all pending compression is done
+func (o *OutputFile) Stop() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	_ = o.file.Close()
+	close(o.rotated)
+	<-o.done
+}
+
+func (o *OutputFile) WriteLine(line string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	length := int64(len(line) + 1)
+	if (o.MaxSize != 0 && o.size != 0 && o.size+length > o.MaxSize) || (o.MaxAge != 0 && time.Since(o.openedAt) >= o.MaxAge) {
+		if err := o.rotate(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: rotating %v: %v\n", o.Path, err.Error()) // untested section
+		}
+	}
+
+	n, err := io.WriteString(o.file, line+"\n")
+	o.size += int64(n)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: writing %v: %v\n", o.Path, err.Error()) // untested section
+	}
+}
+
+func (o *OutputFile) open() error {
+	file, err := os.OpenFile(o.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close() // untested section
+		return err
+	}
+	o.file = file
+	o.size = stat.Size()
+	o.openedAt = time.Now()
+	return nil
+}
+
+// move the current file away and start a new one, compressing and cleaning up in the background
+func (o *OutputFile) rotate() error {
+	if err := o.file.Close(); err != nil {
+		return err // untested section
+	}
+	rotated := o.Path + "." + time.Now().UTC().Format(outputFileTimeFormat)
+	if err := os.Rename(o.Path, rotated); err != nil {
+		return err // untested section
+	}
+	if err := o.open(); err != nil {
+		return err // untested section
+	}
+
+	o.rotated <- rotated
+	return nil
+}
+
+func (o *OutputFile) cleanup(rotated string) {
+	if o.Gzip {
+		if err := gzipFile(rotated); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: compressing %v: %v\n", rotated, err.Error()) // untested section
+		}
+	}
+	o.removeOld(rotated)
+}
+
+// remove the oldest rotated files that are over the retention count,
+// ignoring files rotated after the given one since they are not cleaned up yet
+func (o *OutputFile) removeOld(latest string) {
+	if o.Retain == 0 {
+		return
+	}
+	found, err := filepath.Glob(o.Path + ".*")
+	if err != nil {
+		return // untested section
+	}
+	var rotated []string
+	for _, path := range found {
+		if path <= latest+".gz" {
+			rotated = append(rotated, path)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > o.Retain {
+		_ = os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+func gzipFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err // untested section
+	}
+	defer func() { _ = source.Close() }()
+
+	target, err := os.Create(path + ".gz")
+	if err != nil {
+		return err // untested section
+	}
+	writer := gzip.NewWriter(target)
+	if _, err = io.Copy(writer, source); err == nil {
+		err = writer.Close()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err // untested section
+	}
+	return os.Remove(path)
+}
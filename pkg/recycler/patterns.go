@@ -0,0 +1,165 @@
+package recycler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PatternStats counts how often each pattern was tried and matched, to find patterns that never match
+// and patterns that are tried a lot but should be moved to the front
+type PatternStats struct {
+	patterns []Pattern
+	tried    []uint64
+	matched  []uint64
+	skipped  uint64 // lines that could not match any pattern, so all patterns count them as tried
+	timed    bool   // measure how long each pattern takes, only for benchmarks since it slows down matching
+	nanos    []int64
+}
+
+func NewPatternStats(patterns []Pattern) *PatternStats {
+	return &PatternStats{patterns: patterns, tried: make([]uint64, len(patterns)), matched: make([]uint64, len(patterns)), nanos: make([]int64, len(patterns))}
+}
+
+func (s *PatternStats) Try(i int) {
+	atomic.AddUint64(&s.tried[i], 1)
+}
+
+// Skip counts a line that could not match any pattern, so it was not tried one by one
+func (s *PatternStats) Skip() {
+	atomic.AddUint64(&s.skipped, 1)
+}
+
+// Time adds how long trying the pattern took
+func (s *PatternStats) Time(i int, duration time.Duration) {
+	atomic.AddInt64(&s.nanos[i], int64(duration))
+}
+
+// Match returns how often the pattern matched including this time
+func (s *PatternStats) Match(i int) uint64 {
+	return atomic.AddUint64(&s.matched[i], 1)
+}
+
+// Report a logfmt line per pattern in configured order
+func (s *PatternStats) Report() string {
+	var report strings.Builder
+	for i, pattern := range s.patterns {
+		line := NewOrderedMap()
+		line.Set("pattern", strconv.Itoa(i))
+		if pattern.Name != "" {
+			line.Set("name", pattern.Name)
+		}
+		line.Set("matched", strconv.FormatUint(atomic.LoadUint64(&s.matched[i]), 10))
+		line.Set("tried", strconv.FormatUint(atomic.LoadUint64(&s.tried[i])+atomic.LoadUint64(&s.skipped), 10))
+		if s.timed {
+			line.Set("time", time.Duration(atomic.LoadInt64(&s.nanos[i])).String())
+		}
+		line.Set("regex", pattern.Regex)
+		report.WriteString(line.ToLogfmt() + "\n")
+	}
+	return report.String()
+}
+
+// name of the pattern or its index for unnamed patterns
+func patternLabel(i int, pattern *Pattern) string {
+	if pattern.Name != "" {
+		return pattern.Name
+	}
+	return strconv.Itoa(i)
+}
+
+// submatches of the regex in the message or field, nil when the pattern does not match
+func (p *Pattern) match(log *OrderedMap, messageKey string) []string {
+	key := messageKey
+	if p.Field != "" {
+		key = p.Field
+	}
+	subject, found := log.values[key]
+	if !found || (p.literal != "" && !strings.Contains(subject, p.literal)) {
+		return nil
+	}
+	match := p.regexParsed.FindStringSubmatch(subject)
+	if match == nil || !p.accepts(subject) {
+		return nil
+	}
+	return match
+}
+
+// the regexes besides regex also match or do not match
+func (p *Pattern) accepts(subject string) bool {
+	if p.regexNotParsed != nil && p.regexNotParsed.MatchString(subject) {
+		return false
+	}
+	for _, regex := range p.allOfParsed {
+		if !regex.MatchString(subject) {
+			return false
+		}
+	}
+	return len(p.anyOfParsed) == 0 || matchesAny(p.anyOfParsed, subject)
+}
+
+func matchesAny(regexes []*regexp.Regexp, subject string) bool {
+	for _, regex := range regexes {
+		if regex.MatchString(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternSet finds the first pattern whose regex matches a message with a single scan
+type patternSet struct {
+	regex  *regexp.Regexp
+	groups []int // submatch index of each pattern, followed by the submatches of its regex
+}
+
+// all patterns as one alternation where each alternative can skip ahead, so the first alternative
+// that matches anywhere wins, like trying them in order
+// nil when there are too few patterns to benefit or patterns do not only match the message
+func combinePatterns(patterns []Pattern) (*patternSet, error) {
+	if len(patterns) < 2 {
+		return nil, nil
+	}
+	for _, pattern := range patterns {
+		if pattern.Field != "" {
+			return nil, nil
+		}
+	}
+	alternatives := make([]string, len(patterns))
+	groups := make([]int, len(patterns)+1)
+	groups[0] = 1
+	for i, pattern := range patterns {
+		alternatives[i] = "(?s:.*?)(" + pattern.flags() + pattern.Regex + ")"
+		groups[i+1] = groups[i] + 1 + pattern.regexParsed.NumSubexp()
+	}
+	regex, err := regexp.Compile("^(?:" + strings.Join(alternatives, "|") + ")")
+	if err != nil {
+		return nil, fmt.Errorf("combining the regexes of patterns: %v", err) // untested section
+	}
+	return &patternSet{regex: regex, groups: groups}, nil
+}
+
+// index of the first pattern whose regex matches and its submatches, -1 when no pattern matches
+func (s *patternSet) find(subject string) (int, []string) {
+	indexes := s.regex.FindStringSubmatchIndex(subject)
+	if indexes == nil {
+		return -1, nil
+	}
+	for i := 0; i < len(s.groups)-1; i++ {
+		group := s.groups[i]
+		if indexes[2*group] < 0 {
+			continue
+		}
+		match := make([]string, s.groups[i+1]-group)
+		for j := range match {
+			if start := indexes[2*(group+j)]; start >= 0 {
+				match[j] = subject[start:indexes[2*(group+j)+1]]
+			}
+		}
+		return i, match
+	}
+	return -1, nil // untested section
+}
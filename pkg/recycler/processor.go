@@ -0,0 +1,62 @@
+package recycler
+
+import (
+	"maps"
+	"slices"
+)
+
+// Processor parses and labels lines with a logrecycler config, so go services can embed it instead of piping to it,
+// nothing is reported to metrics or sent to sinks, logs are not aggregated and lines are not joined,
+// inputs and multiline are up to the caller
+type Processor struct {
+	config *Config
+}
+
+// Event is a processed log
+type Event struct {
+	Keys   []string          // in output order
+	Values map[string]string // by key
+	Line   string            // formatted like logrecycler would print it, for example as json
+}
+
+// NewProcessor reads the config file or directory of yaml files, overrides are key=value like `-set`
+func NewProcessor(path string, overrides ...string) (*Processor, error) {
+	config, err := NewConfig(path, overrides...)
+	if err != nil {
+		return nil, err
+	}
+	disableMetrics(config)
+	config.Aggregate = nil // summaries would have nowhere to go
+	return &Processor{config: config}, nil
+}
+
+// Close stops what the config started, like execFilter commands and wasm modules
+func (p *Processor) Close() {
+	if p.config.ExecFilter != nil {
+		p.config.ExecFilter.Stop()
+	}
+	if p.config.Wasm != nil {
+		p.config.Wasm.Stop()
+	}
+}
+
+// Process a line, false when it was discarded, safe to call concurrently
+// use ProcessAll when the config splits lines into multiple logs
+func (p *Processor) Process(line string) (Event, bool) {
+	events := p.ProcessAll(line)
+	if len(events) == 0 {
+		return Event{}, false
+	}
+	return events[0], true
+}
+
+// ProcessAll returns all logs of a line, none when it was discarded
+func (p *Processor) ProcessAll(line string) []Event {
+	logs := processLine(Line{Text: line}, p.config)
+	events := make([]Event, 0, len(logs))
+	for _, log := range logs {
+		events = append(events, Event{Keys: slices.Clone(log.keys), Values: maps.Clone(log.values), Line: formatLine(log, p.config)})
+		releaseOrderedMap(log)
+	}
+	return events
+}
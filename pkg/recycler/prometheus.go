@@ -0,0 +1,415 @@
+package recycler
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"regexp"
+	"sync"
+)
+
+var prometheusLabelRegex = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type Prometheus struct {
+	Port           string
+	Bind           string // address to listen on (default 0.0.0.0)
+	Tls            *PrometheusTls
+	tlsConfig      *tls.Config
+	Username       string // require basic auth for all endpoints
+	Password       string
+	Pprof          bool // serve /debug/pprof
+	RuntimeMetrics bool `yaml:"runtimeMetrics"` // report go runtime and process metrics
+	Pushgateway    *Pushgateway
+	registry       *prometheus.Registry
+	Metric         string
+	Help           string
+	Labels         map[string]string // static labels added to every metric
+	labelNames     []string
+	counter        *prometheus.CounterVec
+	MaxCardinality map[string]int `yaml:"maxCardinality"` // label -> max distinct values before using "other"
+	cardinality    map[string]map[string]struct{}
+	cardinalityMu  sync.Mutex
+	limited        *prometheus.CounterVec
+	patternNames   []string
+	patternMatches *prometheus.CounterVec
+	patternStats   *PatternStats
+	rateLimited    *prometheus.CounterVec
+	unmatched      prometheus.Counter // only when unmatched is configured
+	countUnmatched bool
+	oversized      prometheus.Counter // only when maxEventBytes is configured
+	countOversized bool
+	buffers        map[string]*DiskBuffer // by sink name
+	retries        map[string]*Retry      // by sink name
+	statsd         *Statsd
+	telemetry      *Telemetry
+	top            *Top    // serve /debug/top when set
+	recent         *Recent // serve /debug/recent when set
+	timeouts       *prometheus.CounterVec
+	metrics        []Metric
+	observers      []prometheus.ObserverVec
+	server         *http.Server
+}
+
+// Pushgateway receives the final counts when short-lived jobs finish before they are scraped
+type Pushgateway struct {
+	Url      string
+	Job      string // default logrecycler
+	Instance string // optional grouping label
+}
+
+// PrometheusTls serves metrics over https
+type PrometheusTls struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// load the certificate so a missing or invalid one fails at startup
+func (p *Prometheus) validateServer() error {
+	if p.Bind == "" {
+		p.Bind = "0.0.0.0"
+	}
+	if p.Password != "" && p.Username == "" {
+		return fmt.Errorf("prometheus.password requires prometheus.username")
+	}
+	if p.Tls == nil {
+		return nil
+	}
+	if p.Tls.CertFile == "" || p.Tls.KeyFile == "" {
+		return fmt.Errorf("prometheus.tls.certFile and prometheus.tls.keyFile must be set")
+	}
+	certificate, err := tls.LoadX509KeyPair(p.Tls.CertFile, p.Tls.KeyFile)
+	if err != nil {
+		return fmt.Errorf("prometheus.tls: %v", err)
+	}
+	p.tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	return nil
+}
+
+// register all metrics in a new registry, also used to check the config without starting
+func (p *Prometheus) register() error {
+	// build new empty registry without go spam
+	// https://stackoverflow.com/questions/35117993/how-to-disable-go-collector-metrics-in-prometheus-client-golang
+	p.registry = prometheus.NewRegistry()
+	r := &registerer{Registerer: p.registry}
+	if p.RuntimeMetrics {
+		r.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	p.counter = promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+		Name:        p.Metric,
+		Help:        p.Help,
+		ConstLabels: p.Labels,
+	}, prometheusLabelNames(p.labelNames))
+	p.patternMatches = promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+		Name:        "logrecycler_pattern_matches_total",
+		Help:        "Total number of logs matched by each named pattern",
+		ConstLabels: p.Labels,
+	}, []string{"pattern"})
+	for _, name := range p.patternNames {
+		p.patternMatches.WithLabelValues(name) // show patterns that never match
+	}
+	p.rateLimited = promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+		Name:        "logrecycler_rate_limited_total",
+		Help:        "Total number of logs not output because of the rateLimit of each pattern",
+		ConstLabels: p.Labels,
+	}, []string{"pattern"})
+	if p.countUnmatched {
+		p.unmatched = promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name:        "logrecycler_unmatched_total",
+			Help:        "Total number of lines that matched no pattern",
+			ConstLabels: p.Labels,
+		})
+	}
+	if p.countOversized {
+		p.oversized = promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name:        "logrecycler_oversized_total",
+			Help:        "Total number of logs not output because they exceeded maxEventBytes",
+			ConstLabels: p.Labels,
+		})
+	}
+	for _, sink := range sortedMapKeys(p.buffers) {
+		buffer := p.buffers[sink]
+		labels := p.sinkLabels(sink)
+		promauto.With(r).NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "logrecycler_buffered_logs",
+			Help:        "Number of logs in the disk buffer of each sink waiting to be sent",
+			ConstLabels: labels,
+		}, func() float64 { return float64(buffer.Queued()) })
+		promauto.With(r).NewCounterFunc(prometheus.CounterOpts{
+			Name:        "logrecycler_buffer_dropped_total",
+			Help:        "Total number of logs dropped because the disk buffer of each sink was full",
+			ConstLabels: labels,
+		}, func() float64 { return float64(buffer.Dropped()) })
+	}
+	for _, sink := range sortedMapKeys(p.retries) {
+		retry := p.retries[sink]
+		labels := p.sinkLabels(sink)
+		promauto.With(r).NewCounterFunc(prometheus.CounterOpts{
+			Name:        "logrecycler_sink_retries_total",
+			Help:        "Total number of times sending to each sink was retried",
+			ConstLabels: labels,
+		}, func() float64 { return float64(retry.Retries()) })
+		promauto.With(r).NewCounterFunc(prometheus.CounterOpts{
+			Name:        "logrecycler_sink_circuit_open_seconds_total",
+			Help:        "Total seconds sending to each sink was stopped because it kept failing",
+			ConstLabels: labels,
+		}, retry.OpenSeconds)
+		if !retry.buffered {
+			promauto.With(r).NewCounterFunc(prometheus.CounterOpts{
+				Name:        "logrecycler_sink_dropped_batches_total",
+				Help:        "Total number of batches dropped because sending to each sink was stopped and it has no buffer",
+				ConstLabels: labels,
+			}, func() float64 { return float64(retry.Dropped()) })
+		}
+	}
+	if p.telemetry != nil {
+		r.fail(p.telemetry.register(p.registry, p.Labels))
+	}
+	if p.statsd != nil {
+		statsd := p.statsd
+		promauto.With(r).NewCounterFunc(prometheus.CounterOpts{
+			Name:        "logrecycler_statsd_dropped_total",
+			Help:        "Total number of statsd metrics and events dropped because statsd was not connected",
+			ConstLabels: p.Labels,
+		}, func() float64 { return float64(statsd.Dropped()) })
+	}
+	p.timeouts = promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+		Name:        "logrecycler_pattern_timeouts_total",
+		Help:        "Total number of lines where matching exceeded matchTimeout at each pattern",
+		ConstLabels: p.Labels,
+	}, []string{"pattern"})
+	p.limited = promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+		Name:        "logrecycler_cardinality_limited_total",
+		Help:        "Total number of label values replaced with other because of maxCardinality",
+		ConstLabels: p.Labels,
+	}, []string{"label"})
+	p.cardinality = map[string]map[string]struct{}{}
+	for label := range p.MaxCardinality {
+		p.cardinality[label] = map[string]struct{}{}
+		p.limited.WithLabelValues(label)
+	}
+	p.observers = make([]prometheus.ObserverVec, len(p.metrics))
+	for i, metric := range p.metrics {
+		if metric.Type == "summary" {
+			p.observers[i] = promauto.With(r).NewSummaryVec(prometheus.SummaryOpts{
+				Name:        metric.Name,
+				Help:        metric.Help,
+				ConstLabels: p.Labels,
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			}, prometheusLabelNames(metric.Labels))
+		} else {
+			p.observers[i] = promauto.With(r).NewHistogramVec(prometheus.HistogramOpts{
+				Name:        metric.Name,
+				Help:        metric.Help,
+				ConstLabels: p.Labels,
+				Buckets:     metric.Buckets,
+			}, prometheusLabelNames(metric.Labels))
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("registering metrics: %v", r.err)
+	}
+	return nil
+}
+
+func (p *Prometheus) Start() error {
+	if err := p.register(); err != nil {
+		return err
+	}
+
+	handler := http.NewServeMux()
+	handler.Handle("/", promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{}))
+	handler.HandleFunc("/debug/patterns", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(p.patternStats.Report()))
+	})
+	if p.top != nil {
+		handler.HandleFunc("/debug/top", func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(p.top.Report()))
+		})
+	}
+	if p.recent != nil {
+		handler.HandleFunc("/debug/recent", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(p.recent.Report(r.URL.Query().Get("level"), r.URL.Query().Get("pattern"))))
+		})
+	}
+	if p.Pprof {
+		handler.HandleFunc("/debug/pprof/", pprof.Index)
+		handler.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		handler.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		handler.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		handler.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	// serve metrics, unless only pushing
+	if p.Port == "" && p.Pushgateway != nil {
+		return nil
+	}
+	// listen before serving so a port that is already in use fails startup
+	listener, err := net.Listen("tcp", net.JoinHostPort(p.Bind, p.Port))
+	if err != nil {
+		return err
+	}
+	p.server = &http.Server{Handler: p.authenticate(handler), TLSConfig: p.tlsConfig}
+	if p.tlsConfig != nil {
+		go p.server.ServeTLS(listener, "", "")
+	} else {
+		go p.server.Serve(listener)
+	}
+	return nil
+}
+
+// registerer keeps the first error instead of panicking like promauto does, so bad metrics fail startup with a message
+type registerer struct {
+	prometheus.Registerer
+	err error
+}
+
+func (r *registerer) MustRegister(collectors ...prometheus.Collector) {
+	for _, collector := range collectors {
+		r.fail(r.Register(collector))
+	}
+}
+
+func (r *registerer) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// require basic auth when a username is configured, comparing in constant time to not leak the credentials
+func (p *Prometheus) authenticate(next http.Handler) http.Handler {
+	if p.Username == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(p.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(p.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="logrecycler"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stop serving, waiting for in-flight scrapes to finish, then push final counts
+func (p *Prometheus) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if p.server != nil {
+		_ = p.server.Shutdown(ctx)
+	}
+
+	if p.Pushgateway != nil {
+		pusher := push.New(p.Pushgateway.Url, p.Pushgateway.Job).Gatherer(p.registry)
+		if p.Pushgateway.Instance != "" {
+			pusher = pusher.Grouping("instance", p.Pushgateway.Instance)
+		}
+		if err := pusher.PushContext(ctx); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: pushing to pushgateway: %v\n", err.Error())
+		}
+	}
+}
+
+func (p *Prometheus) Inc(values map[string]string) {
+	p.counter.WithLabelValues(p.labelValues(values)...).Inc()
+}
+
+// Observe all configured metrics that have a numeric value
+func (p *Prometheus) Observe(values map[string]string) {
+	for i, metric := range p.metrics {
+		if value, ok := metric.value(values); ok {
+			p.observers[i].WithLabelValues(metric.labelValues(values)...).Observe(value)
+		}
+	}
+}
+
+func (p *Prometheus) IncPattern(name string) {
+	p.patternMatches.WithLabelValues(name).Inc()
+}
+
+// fields like log.level are valid field names but not valid label names
+func prometheusLabelNames(labels []string) []string {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = prometheusLabelRegex.ReplaceAllString(label, "_")
+	}
+	return names
+}
+
+// pattern is the name or index of the pattern
+func (p *Prometheus) IncRateLimited(pattern string) {
+	p.rateLimited.WithLabelValues(pattern).Inc()
+}
+
+// constant labels of metrics about a sink
+func (p *Prometheus) sinkLabels(sink string) prometheus.Labels {
+	labels := prometheus.Labels{"sink": sink}
+	for key, value := range p.Labels {
+		labels[key] = value
+	}
+	return labels
+}
+
+func (p *Prometheus) IncUnmatched() {
+	if p.unmatched != nil {
+		p.unmatched.Inc()
+	}
+}
+
+func (p *Prometheus) IncOversized() {
+	if p.oversized != nil {
+		p.oversized.Inc()
+	}
+}
+
+// pattern is the name or index of the pattern that exceeded the timeout
+func (p *Prometheus) IncPatternTimeout(pattern string) {
+	p.timeouts.WithLabelValues(pattern).Inc()
+}
+
+// build values array in correct order to avoid overhead from prometheus validation code + blowing up on missing labels
+func (p *Prometheus) labelValues(labelMap map[string]string) []string {
+	values := make([]string, len(p.labelNames))
+
+	for i, label := range p.labelNames {
+		if value, found := labelMap[label]; found {
+			values[i] = value
+		} else {
+			values[i] = ""
+		}
+		if max, found := p.MaxCardinality[label]; found {
+			values[i] = p.limitCardinality(label, values[i], max)
+		}
+	}
+	return values
+}
+
+// replace values that were not seen before once the label has max values
+func (p *Prometheus) limitCardinality(label string, value string, max int) string {
+	p.cardinalityMu.Lock()
+	defer p.cardinalityMu.Unlock()
+
+	seen := p.cardinality[label]
+	if _, found := seen[value]; found {
+		return value
+	}
+	if len(seen) < max {
+		seen[value] = struct{}{}
+		return value
+	}
+	p.limited.WithLabelValues(label).Inc()
+	return "other"
+}
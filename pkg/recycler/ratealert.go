@@ -0,0 +1,152 @@
+package recycler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// RateAlert warns about log storms or silence of a named pattern by comparing its matches per interval
+// to their moving average (ewma), posting to a webhook or logging an ERROR when there is no url
+type RateAlert struct {
+	Pattern        string
+	Interval       time.Duration // (default 1m)
+	Factor         float64       // alert when the rate is this many times above or below the average (default 3)
+	Smoothing      float64       // weight of the last interval in the average (default 0.3)
+	Warmup         int           // intervals to learn the average before alerting (default 5)
+	Url            string        // webhook, logs an ERROR when not set
+	Template       string        // payload, default is for slack
+	templateParsed *template.Template
+	lock           sync.Mutex
+	count          int
+	average        float64
+	intervals      int
+	client         *http.Client
+	sending        sync.WaitGroup
+	stop           chan struct{}
+	done           sync.WaitGroup
+}
+
+func (r *RateAlert) validate(location string, patternNames []string) error {
+	if !contains(patternNames, r.Pattern) {
+		return fmt.Errorf("%v.pattern must be the name of a pattern but was %v", location, r.Pattern)
+	}
+	if r.Interval == 0 {
+		r.Interval = time.Minute
+	}
+	if r.Interval < 0 {
+		return fmt.Errorf("%v.interval must be more than 0 but was %v", location, r.Interval)
+	}
+	if r.Factor == 0 {
+		r.Factor = 3
+	}
+	if r.Factor <= 1 {
+		return fmt.Errorf("%v.factor must be above 1 but was %v", location, r.Factor)
+	}
+	if r.Smoothing == 0 {
+		r.Smoothing = 0.3
+	}
+	if r.Smoothing < 0 || r.Smoothing > 1 {
+		return fmt.Errorf("%v.smoothing must be between 0.0 - 1.0 but was %v", location, r.Smoothing)
+	}
+	if r.Warmup == 0 {
+		r.Warmup = 5
+	}
+	var err error
+	r.templateParsed, err = parseAlertTemplate(location, r.Template)
+	return err
+}
+
+// Match counts a matching line for the current interval
+func (r *RateAlert) Match() {
+	r.lock.Lock()
+	r.count++
+	r.lock.Unlock()
+}
+
+// Start comparing the rate after every interval, output is used for ERROR logs when there is no url
+func (r *RateAlert) Start(config *Config, output func(*OrderedMap)) {
+	r.client = &http.Client{Timeout: 10 * time.Second}
+	r.stop = make(chan struct{})
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.tick(config, output)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for alerts that are being sent, the current interval is incomplete so it is not compared
+func (r *RateAlert) Stop() {
+	close(r.stop)
+	r.done.Wait()
+	r.sending.Wait()
+}
+
+func (r *RateAlert) tick(config *Config, output func(*OrderedMap)) {
+	r.lock.Lock()
+	rate, average := r.count, r.average
+	warm := r.intervals >= r.Warmup
+	if r.intervals == 0 {
+		r.average = float64(rate)
+	} else {
+		r.average = r.Smoothing*float64(rate) + (1-r.Smoothing)*r.average
+	}
+	r.intervals++
+	r.count = 0
+	r.lock.Unlock()
+
+	direction := ""
+	if float64(rate) > average*r.Factor {
+		direction = "above"
+	} else if float64(rate)*r.Factor < average {
+		direction = "below"
+	}
+	if !warm || direction == "" {
+		return
+	}
+	text := fmt.Sprintf("pattern %v matched %v times in %v, %v %v times the average of %.1f",
+		r.Pattern, rate, r.Interval, direction, r.Factor, average)
+
+	if r.Url == "" {
+		log := acquireOrderedMap()
+		if config.timestampKeySet {
+			log.Set(config.TimestampKey, time.Now().Format(timeFormat))
+		}
+		if config.levelKeySet {
+			log.Set(config.LevelKey, "ERROR")
+		}
+		log.Set(config.MessageKey, text)
+		log.Set("pattern", r.Pattern)
+		log.Set("rate", strconv.Itoa(rate))
+		log.SetType("rate", "int")
+		log.Set("average", strconv.FormatFloat(average, 'f', 1, 64))
+		log.SetType("average", "float")
+		output(log)
+		return
+	}
+
+	data := map[string]interface{}{
+		"pattern":  r.Pattern,
+		"rate":     rate,
+		"average":  average,
+		"interval": r.Interval.String(),
+		"text":     text,
+	}
+	r.sending.Add(1)
+	go func() {
+		defer r.sending.Done()
+		postAlert(r.client, r.Url, r.templateParsed, data)
+	}()
+}
@@ -0,0 +1,70 @@
+package recycler
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit only outputs count matching lines per duration, metrics still count all
+type RateLimit struct {
+	Count       int
+	Per         time.Duration
+	Summary     bool // output "suppressed N similar lines" with the first line of the next window
+	lock        sync.Mutex
+	windowStart time.Time
+	allowed     int
+	suppressed  int
+}
+
+// Allow returns if the line should be output and how many lines were suppressed in the previous window
+func (r *RateLimit) Allow(now time.Time) (bool, int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	suppressed := 0
+	if now.Sub(r.windowStart) >= r.Per {
+		suppressed = r.suppressed
+		r.windowStart = now
+		r.allowed = 0
+		r.suppressed = 0
+	}
+
+	if r.allowed >= r.Count {
+		r.suppressed++
+		return false, suppressed
+	}
+	r.allowed++
+	return true, suppressed
+}
+
+func (r *RateLimit) validate(location string) error {
+	if r.Count < 1 {
+		return fmt.Errorf("%v.count must be 1 or more but was %d", location, r.Count)
+	}
+	if r.Per <= 0 {
+		return fmt.Errorf("%v.per must be set", location)
+	}
+	return nil
+}
+
+// summary log for lines suppressed by the rate limit of a pattern
+func rateLimitSummary(suppressed int, pattern *Pattern, config *Config) *OrderedMap {
+	summary := NewOrderedMap()
+	if config.timestampKeySet {
+		summary.Set(config.TimestampKey, time.Now().Format(timeFormat))
+	}
+	if config.levelKeySet {
+		level := "INFO"
+		if pattern.levelSet {
+			level = pattern.Level
+		}
+		summary.Set(config.LevelKey, level)
+	}
+	summary.Set(config.MessageKey, "suppressed "+strconv.Itoa(suppressed)+" similar lines")
+	if pattern.Name != "" {
+		summary.Set("pattern", pattern.Name)
+	}
+	return summary
+}
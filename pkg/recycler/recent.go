@@ -0,0 +1,75 @@
+package recycler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Recent keeps the last logs in memory, to peek at the output without access to the log store,
+// served as json at prometheus /debug/recent, filtered with ?level=ERROR&pattern=crash
+type Recent struct {
+	Size   int // logs to keep (default 100)
+	lock   sync.Mutex
+	events []recentEvent // ring buffer, next is the oldest once full
+	next   int
+}
+
+type recentEvent struct {
+	level   string
+	pattern string // name or index of the matched pattern, none when unmatched
+	json    string
+}
+
+func (r *Recent) validate(config *Config) error {
+	if config.Prometheus == nil {
+		return fmt.Errorf("recent requires prometheus to be configured")
+	}
+	if r.Size == 0 {
+		r.Size = 100
+	}
+	if r.Size < 0 {
+		return fmt.Errorf("recent.size must be positive but was %v", r.Size)
+	}
+	r.events = make([]recentEvent, 0, r.Size)
+	return nil
+}
+
+// Add the log, replacing the oldest when full
+func (r *Recent) Add(log *OrderedMap, level string, pattern string) {
+	if pattern == "" {
+		pattern = "none"
+	}
+	event := recentEvent{level: level, pattern: pattern, json: log.ToJson()}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.events) < r.Size {
+		r.events = append(r.events, event)
+		return
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % r.Size
+}
+
+// Report a json array of the logs, oldest first, empty filters match all
+func (r *Recent) Report(level string, pattern string) string {
+	r.lock.Lock()
+	events := append(append([]recentEvent(nil), r.events[r.next:]...), r.events[:r.next]...)
+	r.lock.Unlock()
+
+	var report strings.Builder
+	report.WriteString("[")
+	first := true
+	for _, event := range events {
+		if level != "" && !strings.EqualFold(event.level, level) || pattern != "" && event.pattern != pattern {
+			continue
+		}
+		if !first {
+			report.WriteString(",")
+		}
+		first = false
+		report.WriteString(event.json)
+	}
+	report.WriteString("]")
+	return report.String()
+}
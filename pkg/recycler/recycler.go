@@ -0,0 +1,1109 @@
+package recycler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// terminal escape sequences like colors, cursor movement and window titles
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// how long to wait for already read lines to be processed when shutting down
+var drainTimeout = 100 * time.Millisecond
+
+// how long to wait for metric backends to finish when shutting down
+var shutdownTimeout = 5 * time.Second
+
+// exit code of all commands when flags, config or sinks are invalid, like flag.ExitOnError
+const exitFailure = 2
+
+// Run the command given in os.Args and return its exit code, so all cleanup is done before exiting
+func Run() int {
+	args := os.Args[1:]
+	if len(args) != 0 {
+		switch args[0] {
+		case "run":
+			return runPipeline(args[1:])
+		case "check":
+			return runCheck(args[1:])
+		case "test":
+			return runTest(args[1:])
+		case "bench":
+			return runBench(args[1:])
+		case "replay":
+			return runReplay(args[1:])
+		}
+	}
+	return runPipeline(args)
+}
+
+// process logs from stdin, a command or the configured inputs until they end
+func runPipeline(args []string) int {
+	args, command := splitArrayOn(args, "--")
+	set, flags := newFlagSet("logrecycler", usageText)
+	version := set.Bool("version", false, "Show version")
+	if code, done := parseFlags(set, args); done {
+		return code
+	}
+	if *version {
+		fmt.Println(versionInfo())
+		return 0
+	}
+	if set.NArg() != 0 {
+		set.Usage()
+		return exitFailure
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		return exitFailure
+	}
+
+	// prevent unsupported dual/no-input usage
+	if len(config.Inputs) == 0 && isPipingToStdin() == (len(command) != 0) {
+		// untested section
+		set.Usage()
+		return exitFailure
+	}
+
+	if len(config.Inputs) != 0 && len(command) != 0 {
+		// untested section
+		_, _ = fmt.Fprintln(os.Stderr, "Error: inputs and command cannot be used together")
+		return exitFailure
+	}
+
+	return pipeline(config, command, nil)
+}
+
+// output and report the lines of the replay, the configured inputs, the command or stdin until they end
+func pipeline(config *Config, command []string, replay *Replay) int {
+	config.stdout = NewOutput(os.Stdout, config.BufferSize, config.FlushInterval)
+	defer config.stdout.Stop()
+	if config.stderrLevels != nil {
+		config.stderr = NewOutput(os.Stderr, config.BufferSize, config.FlushInterval)
+		defer config.stderr.Stop()
+	}
+
+	if config.ExecFilter != nil {
+		defer config.ExecFilter.Stop()
+	}
+	if config.Wasm != nil {
+		defer config.Wasm.Stop()
+	}
+
+	if config.OutputFile != nil {
+		if err := config.OutputFile.Start(); err != nil {
+			// untested section
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+			return exitFailure
+		}
+		defer config.OutputFile.Stop()
+	}
+
+	if config.Prometheus != nil {
+		if err := config.Prometheus.Start(); err != nil {
+			if !sinkFailed(config, "prometheus", err) {
+				return exitFailure
+			}
+			config.Prometheus = nil
+		} else {
+			defer config.Prometheus.Stop()
+		}
+	}
+
+	if config.Statsd != nil {
+		config.Statsd.Start()
+		defer config.Statsd.Stop()
+	}
+
+	if config.telemetry != nil && config.Statsd != nil {
+		config.telemetry.Start(config.Statsd)
+		defer config.telemetry.Stop()
+	}
+
+	if config.Loki != nil {
+		config.Loki.Start()
+		defer config.Loki.Stop()
+	}
+
+	if config.Elasticsearch != nil {
+		config.Elasticsearch.Start()
+		defer config.Elasticsearch.Stop()
+	}
+
+	// dump throughput, memory, pattern stats and top messages to see what a live logrecycler is doing
+	// and to find patterns that never match, wrapped commands do not get the signal
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, syscall.SIGUSR1)
+	defer signal.Stop(dump)
+	go func() {
+		// untested section
+		for range dump {
+			report := config.stats.Report(config.patternStats)
+			if config.Top != nil {
+				report += config.Top.Report()
+			}
+			_, _ = fmt.Fprint(os.Stderr, report)
+		}
+	}()
+
+	if config.Kafka != nil {
+		config.Kafka.Start()
+		defer config.Kafka.Stop()
+	}
+
+	if config.Splunk != nil {
+		config.Splunk.Start()
+		defer config.Splunk.Stop()
+	}
+
+	if config.Otlp != nil {
+		if err := config.Otlp.Start(); err != nil {
+			if !sinkFailed(config, "otlp", err) {
+				return exitFailure
+			}
+			config.Otlp = nil
+		} else {
+			defer config.Otlp.Stop()
+		}
+	}
+
+	if config.OtlpMetrics != nil {
+		if err := config.OtlpMetrics.Start(); err != nil {
+			if !sinkFailed(config, "otlpMetrics", err) {
+				return exitFailure
+			}
+			config.OtlpMetrics = nil
+		} else {
+			defer config.OtlpMetrics.Stop()
+		}
+	}
+
+	if config.Slack != nil {
+		config.Slack.Start()
+		defer config.Slack.Stop()
+	}
+
+	for i := range config.Alerts {
+		alert := &config.Alerts[i]
+		alert.Start()
+		defer alert.Stop()
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	lines := make(chan Line)
+	var readers sync.WaitGroup
+	var exit chan (int)
+
+	if replay != nil {
+		// read from a recorded file
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			replay.ReadLines(lines, config)
+		}()
+	} else if len(config.Inputs) != 0 {
+		// read from files
+		for i := range config.Inputs {
+			input := &config.Inputs[i]
+			if err := input.Open(); err != nil {
+				// untested section
+				_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+				return exitFailure
+			}
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				input.ReadLines(lines, config)
+			}()
+		}
+	} else if len(command) != 0 {
+		// read from command
+		stdout, stderr, commandExit, err := executeCommand(command)
+		if err != nil {
+			// untested section
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			return exitFailure
+		}
+		exit = commandExit
+		for stream, reader := range map[string]*os.File{"stdout": stdout, "stderr": stderr} {
+			readers.Add(1)
+			go func(stream string, reader *os.File) {
+				defer readers.Done()
+				readLines(reader, stream, lines, config)
+				_ = reader.Close()
+			}(stream, reader)
+		}
+	} else {
+		// read from stdin
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			readLines(os.Stdin, "", lines, config)
+		}()
+	}
+	go func() {
+		readers.Wait()
+		close(lines)
+	}()
+
+	// a wrapped command gets the signal forwarded and we stop when it exits,
+	// otherwise we stop reading and finish processing what was already read
+	var shutdown chan os.Signal
+	if exit == nil {
+		shutdown = make(chan os.Signal, 1)
+		signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(shutdown)
+	}
+
+	if config.Aggregate != nil {
+		config.Aggregate.Start(config, func(log *OrderedMap) {
+			output(log, config)
+			releaseOrderedMap(log)
+		})
+		defer config.Aggregate.Stop()
+	}
+
+	if config.Dedup != nil {
+		config.Dedup.Start(config, func(log *OrderedMap) {
+			output(log, config)
+			releaseOrderedMap(log)
+		})
+		defer config.Dedup.Stop()
+	}
+
+	for i := range config.RateAlerts {
+		alert := &config.RateAlerts[i]
+		alert.Start(config, func(log *OrderedMap) {
+			output(log, config)
+			releaseOrderedMap(log)
+		})
+		defer alert.Stop()
+	}
+
+	// process the stream line by line
+	processLines(lines, shutdown, config)
+
+	// exit with the exit code of the command
+	if exit != nil {
+		return <-exit
+	}
+
+	return 0
+}
+
+// report a sink that could not start, true when running without it
+func sinkFailed(config *Config, name string, err error) bool {
+	if config.SinkFailure == "passthrough" {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v: %v, continuing without it\n", name, err.Error())
+		return true
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Error: %v: %v\n", name, err.Error())
+	return false
+}
+
+func processLines(lines chan Line, shutdown chan os.Signal, config *Config) {
+	lines = untilShutdown(lines, shutdown, config)
+
+	if config.Workers <= 1 {
+		for line := range lines {
+			for _, log := range measuredProcessLine(line, config) {
+				output(log, config)
+				releaseOrderedMap(log)
+			}
+		}
+		return
+	}
+
+	// process in parallel, but output in the order the lines were read
+	type job struct {
+		line   Line
+		result chan []*OrderedMap
+	}
+	jobs := make(chan job, config.Workers)
+	results := make(chan chan []*OrderedMap, config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.result <- measuredProcessLine(j.line, config)
+			}
+		}()
+	}
+	go func() {
+		for line := range lines {
+			j := job{line: line, result: make(chan []*OrderedMap, 1)}
+			results <- j.result
+			jobs <- j
+		}
+		close(jobs)
+		close(results)
+	}()
+	for result := range results {
+		for _, log := range <-result {
+			output(log, config)
+			releaseOrderedMap(log)
+		}
+	}
+}
+
+// processLine and count it for SIGUSR1 and selfMetrics
+func measuredProcessLine(line Line, config *Config) []*OrderedMap {
+	if config.telemetry == nil {
+		logs := processLine(line, config)
+		config.stats.Processed(len(logs))
+		return logs
+	}
+	started := time.Now()
+	logs := processLine(line, config)
+	config.stats.Processed(len(logs))
+	config.telemetry.Processed(line, len(logs), time.Since(started))
+	return logs
+}
+
+// pass lines along until shutdown, then pass along what is still coming in, but do not wait for blocked readers
+func untilShutdown(lines chan Line, shutdown chan os.Signal, config *Config) chan Line {
+	passed := make(chan Line)
+	go func() {
+		defer close(passed)
+		for {
+			select {
+			case line, open := <-lines:
+				if !open {
+					return
+				}
+				passed <- line
+			case <-shutdown:
+				for i := range config.Inputs {
+					config.Inputs[i].Stop()
+				}
+				deadline := time.After(drainTimeout) // inputs that keep writing must not delay the exit
+				for {
+					select {
+					case line, open := <-lines:
+						if !open {
+							return
+						}
+						passed <- line
+					case <-deadline:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return passed
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("LOGRECYCLER_CONFIG"); path != "" {
+		return path
+	}
+	return "logrecycler.yaml"
+}
+
+// write a processed line (nil when discarded)
+func output(log *OrderedMap, config *Config) {
+	if log == nil {
+		return
+	}
+	outputs := routeOutputs(log, config)
+	routed := func(name string) bool { return outputs == nil || outputs[name] }
+	line := formatLine(log, config)
+	if config.MaxEventBytes != 0 && len(line) > config.MaxEventBytes {
+		if config.Prometheus != nil {
+			config.Prometheus.IncOversized()
+		}
+		return
+	}
+
+	if routed("stdout") {
+		if config.stderr != nil && config.stderrLevels[strings.ToUpper(log.values[config.LevelKey])] {
+			config.stderr.WriteLine(line)
+		} else {
+			config.stdout.WriteLine(line)
+		}
+		if config.telemetry != nil {
+			config.telemetry.Emitted(line)
+		}
+	}
+
+	if config.OutputFile != nil && routed("file") {
+		config.OutputFile.WriteLine(line)
+	}
+
+	if config.Loki != nil && routed("loki") {
+		config.Loki.Push(log, line)
+	}
+
+	if config.Elasticsearch != nil && routed("elasticsearch") {
+		config.Elasticsearch.Push(log)
+	}
+
+	if config.Kafka != nil && routed("kafka") {
+		config.Kafka.Push(log)
+	}
+
+	if config.Splunk != nil && routed("splunk") {
+		config.Splunk.Push(log)
+	}
+
+	if config.Otlp != nil && routed("otlp") {
+		config.Otlp.Push(log)
+	}
+
+	if config.Slack != nil && routed("slack") {
+		config.Slack.Push(line)
+	}
+}
+
+func formatLine(log *OrderedMap, config *Config) string {
+	if log.raw != nil {
+		return *log.raw
+	}
+	if config.outputTemplateParsed != nil {
+		var buffer strings.Builder
+		if err := config.outputTemplateParsed.Execute(&buffer, log.values); err != nil {
+			// untested section
+			_, _ = fmt.Fprintf(os.Stderr, "Error: executing outputTemplate: %v\n", err.Error())
+			return log.ToJson()
+		}
+		return buffer.String()
+	}
+	if config.logfmt {
+		return log.ToLogfmt()
+	}
+	return log.ToJson()
+}
+
+// everything in here needs to be extra efficient and safe to call concurrently
+// returns the logs to output, nil when the line was discarded
+func processLine(line Line, config *Config) []*OrderedMap {
+	if config.Split != nil {
+		if parts := config.Split.Parts(line.Text); parts != nil {
+			var logs []*OrderedMap
+			for _, event := range config.Split.Lines(line, parts) {
+				logs = append(logs, processEvent(event, config)...)
+			}
+			return logs
+		}
+	}
+	return processEvent(line, config)
+}
+
+// process a single event, see processLine
+func processEvent(line Line, config *Config) []*OrderedMap {
+	// build log line ... sets the json key order too
+	log := acquireOrderedMap()
+	kept := false
+	defer func() {
+		if !kept {
+			releaseOrderedMap(log) // discarded
+		}
+	}()
+	var alerts []*Alert
+	redacted := false
+	defer func() {
+		// samples are sent to webhooks, so they are taken from the final message and never contain secrets
+		if len(alerts) != 0 {
+			sample := log.values[config.MessageKey]
+			if !redacted {
+				sample = redactValue(sample, config) // discarded before redaction
+			}
+			for _, alert := range alerts {
+				alert.Match(sample, time.Now())
+			}
+		}
+	}()
+	log.nested = config.NestedOutput
+	if config.timestampKeySet {
+		now := time.Now()
+		if config.TimestampParse != nil {
+			if parsed, ok := config.TimestampParse.parse(line.Text, now); ok {
+				now = parsed
+			}
+		}
+		log.Set(config.TimestampKey, now.Format(timeFormat))
+	}
+	if config.levelKeySet {
+		log.Set(config.LevelKey, "INFO")
+	}
+	log.Set(config.MessageKey, line.Text)
+	if config.streamKeySet && line.Stream != "" {
+		log.Set(config.StreamKey, line.Stream)
+	}
+	if line.Truncated {
+		log.Set("truncated", "true")
+	}
+	if line.Fields != nil {
+		for _, key := range line.Fields.keys {
+			log.Set(key, line.Fields.values[key])
+			if valueType, found := line.Fields.types[key]; found {
+				log.SetType(key, valueType)
+			}
+		}
+	}
+	if config.Kubernetes != nil {
+		config.Kubernetes.Enrich(log)
+	}
+	addFields(log, config.Add, config.addTemplated)
+	if config.VersionKey != "" {
+		log.Set(config.VersionKey, Version)
+	}
+
+	// remove terminal colors so they do not break patterns
+	if config.StripAnsi {
+		log.values[config.MessageKey] = stripAnsi(log.values[config.MessageKey])
+	}
+
+	// preprocess the log line for general purpose cleanup
+	preprocessed := false
+	for _, step := range config.Preprocess {
+		if step.Replace != nil {
+			replaced := step.regexParsed.ReplaceAllString(log.values[config.MessageKey], *step.Replace)
+			preprocessed = preprocessed || replaced != log.values[config.MessageKey]
+			log.values[config.MessageKey] = replaced
+		} else if match := step.regexParsed.FindStringSubmatch(log.values[config.MessageKey]); match != nil {
+			log.StoreNamedCaptures(step.regexParsed, &match)
+			preprocessed = true
+		}
+	}
+
+	// parse out the container runtime prefix, it wraps everything the container logged
+	if config.Cri {
+		captureCri(config, log)
+	}
+	if config.Docker {
+		captureDocker(config, log)
+	}
+	if config.Heroku {
+		captureHeroku(config, log)
+	}
+
+	// parse out syslog headers, they can wrap other headers
+	if config.Syslog {
+		captureSyslog(config, log)
+	}
+
+	// parse out glog style headers
+	header := ""
+	if config.glogSet {
+		header = captureHeader(config, log)
+	}
+
+	// parse our json
+	if config.jsonSet {
+		message := log.values[config.MessageKey]
+		if strings.HasPrefix(message, "{") && strings.HasSuffix(message, "}") {
+			captureJson(config, log)
+		}
+	}
+
+	// parse columns
+	if config.Csv != nil {
+		config.Csv.Capture(log, log.values[config.MessageKey])
+	}
+
+	// parse key=value pairs
+	config.Kv.Capture(log, log.values[config.MessageKey])
+
+	// apply pattern rules if any
+	var ignoreMetricLabels []string
+	var statsdMetric *PatternStatsd
+	var statsdEvent *StatsdEvent
+	minLevelRank := config.minLevelRank
+	sampled := false
+	var emit []*OrderedMap
+	matched := ""
+	anyMatched := false
+	timedOut := false
+	levelFromPattern := false
+	var matchStarted time.Time
+	if config.DebugKey != "" {
+		matchStarted = time.Now()
+	}
+	patterns := config.Patterns
+	first := 0
+	var found []string // submatches of the first pattern when it was found with the combined regex
+	if config.patternsCombined != nil {
+		subject := log.values[config.MessageKey]
+		first, found = config.patternsCombined.find(subject)
+		if first == -1 {
+			config.patternStats.Skip()
+			first, patterns = 0, nil
+		} else {
+			for i := 0; i < first; i++ {
+				config.patternStats.Try(i)
+			}
+			// conditions besides the regex can still reject it, then the patterns after it are tried
+			if pattern := &patterns[first]; pattern.literal != "" && !strings.Contains(subject, pattern.literal) || !pattern.accepts(subject) {
+				found = nil
+				config.patternStats.Try(first)
+				first++
+			}
+		}
+	}
+	for i := first; i < len(patterns); i++ {
+		pattern := patterns[i]
+		config.patternStats.Try(i)
+		var started time.Time
+		if config.patternStats.timed || config.MatchTimeout != 0 {
+			started = time.Now()
+		}
+		var match []string
+		if found != nil && i == first {
+			match = found
+		} else {
+			match = pattern.match(log, config.MessageKey)
+		}
+		if !started.IsZero() {
+			duration := time.Since(started)
+			if config.patternStats.timed {
+				config.patternStats.Time(i, duration)
+			}
+
+			// regex matching cannot be interrupted, so report the slow pattern but keep what it found
+			if config.MatchTimeout != 0 && duration > config.MatchTimeout {
+				label := patternLabel(i, &pattern)
+				if !timedOut {
+					log.Set("pattern_timeout", label)
+				}
+				if config.Prometheus != nil {
+					config.Prometheus.IncPatternTimeout(label)
+				}
+				timedOut = true
+			}
+		}
+
+		if match != nil {
+			if pattern.whenParsed != nil && !matchesCondition(pattern.whenParsed, log, pattern.regexParsed, match) {
+				continue
+			}
+			matches := config.patternStats.Match(i)
+			anyMatched = true
+
+			if config.Prometheus != nil && pattern.Name != "" {
+				config.Prometheus.IncPattern(pattern.Name)
+			}
+
+			alerts = config.alertsByPattern[pattern.Name]
+			for _, alert := range config.rateAlertsByPattern[pattern.Name] {
+				alert.Match()
+			}
+
+			if pattern.Discard {
+				return nil
+			}
+
+			if pattern.SampleRate != nil {
+				if rand.Float32() > *pattern.SampleRate {
+					return nil
+				}
+			}
+
+			// set level
+			if pattern.levelSet {
+				log.values[config.LevelKey] = pattern.Level
+				levelFromPattern = true
+			}
+
+			log.StoreCaptures(pattern.captureNames, match)
+			pattern.Kv.Capture(log, log.values[config.MessageKey])
+			addFields(log, pattern.Add, pattern.addTemplated)
+			renameAndRemoveFields(log, pattern.Rename, pattern.Remove)
+			replaceFields(log, pattern.Replace)
+			for field, kind := range pattern.Types {
+				log.SetType(field, kind)
+			}
+			if pattern.lua != nil && !pattern.lua.Apply(log) {
+				return nil
+			}
+
+			// keep the 1st, N+1th, ... match
+			if pattern.Sample > 1 && (matches-1)%uint64(pattern.Sample) != 0 {
+				sampled = true
+			}
+
+			if pattern.RateLimit != nil {
+				allowed, suppressed := pattern.RateLimit.Allow(time.Now())
+				if !allowed {
+					sampled = true
+					if config.Prometheus != nil {
+						config.Prometheus.IncRateLimited(patternLabel(i, &pattern))
+					}
+				}
+				if suppressed != 0 && pattern.RateLimit.Summary {
+					emit = append(emit, rateLimitSummary(suppressed, &pattern, config))
+				}
+			}
+
+			ignoreMetricLabels = pattern.IgnoreMetricLabels
+			statsdMetric = pattern.Statsd
+			statsdEvent = pattern.StatsdEvent
+			if pattern.discardBelowRank != 0 {
+				minLevelRank = pattern.discardBelowRank
+			}
+			if config.DebugKey != "" || config.Recent != nil {
+				matched = patternLabel(i, &pattern)
+			}
+
+			break // a line can only match one pattern
+		}
+	}
+
+	// normalize levels set by headers, json and patterns
+	if config.LevelMap != nil {
+		if level, found := config.LevelMap[strings.ToUpper(log.values[config.LevelKey])]; found {
+			log.values[config.LevelKey] = level
+		}
+	}
+
+	// guess the level of lines that are still at the default
+	if config.LevelKeywords.Enabled && !levelFromPattern && log.values[config.LevelKey] == "INFO" {
+		if level, found := config.LevelKeywords.Infer(log.values[config.MessageKey]); found {
+			log.values[config.LevelKey] = level
+		}
+	}
+
+	// lines no pattern claimed can be output untouched or dropped
+	if !anyMatched && !timedOut {
+		if config.Prometheus != nil {
+			config.Prometheus.IncUnmatched()
+		}
+		if config.telemetry != nil {
+			config.telemetry.Unmatched()
+		}
+		switch config.Unmatched {
+		case "discard":
+			return nil
+		case "passthrough":
+			raw := line.Text
+			log.raw = &raw
+		}
+	}
+
+	// group unknown log shapes by their template
+	if config.Fingerprint != nil {
+		config.Fingerprint.Apply(log, config.MessageKey)
+	}
+
+	// map fields through lookup tables and geoip databases
+	for i := range config.Lookups {
+		config.Lookups[i].Apply(log)
+	}
+	for i := range config.Geoip {
+		config.Geoip[i].Enrich(log)
+	}
+	if config.ExecFilter != nil {
+		config.ExecFilter.Apply(log)
+	}
+	if config.Wasm != nil && !config.Wasm.Apply(log) {
+		return nil
+	}
+	if config.lua != nil && !config.lua.Apply(log) {
+		return nil
+	}
+
+	// explain which rules claimed the line
+	if config.DebugKey != "" {
+		log.Set(config.DebugKey, debugValue(config, matched, preprocessed, header, time.Since(matchStarted)))
+	}
+
+	renameAndRemoveFields(log, config.Rename, config.Remove)
+	replaceFields(log, config.Replace)
+
+	// captures from json or headers can still have colors
+	if config.StripAnsiCaptures {
+		for _, key := range log.keys {
+			log.values[key] = stripAnsi(log.values[key])
+		}
+	}
+
+	// mask secrets everywhere before they leave the process
+	if len(config.Redact) != 0 {
+		redact(log, config)
+	}
+	redacted = true
+
+	// count after redaction since the report is served over http
+	if config.Top != nil {
+		config.Top.Add(log.values[config.MessageKey])
+	}
+	if config.Hash != nil {
+		config.Hash.Apply(log)
+	}
+
+	// protect downstream ingestion limits
+	if config.MaxFieldLength != 0 {
+		for _, key := range log.keys {
+			log.values[key] = truncateValue(log.values[key], config.MaxFieldLength)
+		}
+	}
+
+	// report to metrics backends
+	if config.Prometheus != nil || config.Statsd != nil || config.OtlpMetrics != nil {
+		labels := metricLabels(log, config, ignoreMetricLabels)
+		if config.Prometheus != nil {
+			config.Prometheus.Inc(labels)
+			config.Prometheus.Observe(log.values)
+		}
+		if config.Statsd != nil {
+			if statsdMetric != nil {
+				config.Statsd.Report(statsdMetric, labels)
+			} else {
+				config.Statsd.Inc(labels)
+			}
+			if statsdEvent != nil {
+				config.Statsd.Event(statsdEvent, log, log.values[config.MessageKey], labels)
+			}
+		}
+		if config.OtlpMetrics != nil {
+			config.OtlpMetrics.Inc(labels)
+			config.OtlpMetrics.Observe(log.values)
+		}
+		releaseLabels(labels)
+	}
+
+	if sampled {
+		return emit
+	}
+
+	// only output important logs, unknown levels are always important
+	if minLevelRank != 0 {
+		if rank, found := levelRanks[strings.ToUpper(log.values[config.LevelKey])]; found && rank < minLevelRank {
+			return emit
+		}
+	}
+
+	// suppress repeated logs, metrics still count them
+	if config.Dedup != nil && !config.Dedup.Allow(log, time.Now()) {
+		return emit
+	}
+
+	// count instead of output, metrics still count all
+	if config.Aggregate != nil && config.Aggregate.Add(log) && !config.Aggregate.Keep {
+		return emit
+	}
+
+	// stable schema for downstream consumers
+	if config.outputFieldsSet != nil {
+		log.Select(config.OutputFields, config.outputFieldsSet, config.ExtraKey)
+	}
+
+	if config.Recent != nil {
+		config.Recent.Add(log, log.values[config.LevelKey], matched)
+	}
+
+	kept = true
+	return append(emit, log)
+}
+
+// logfmt of the matched pattern (name, index or none), the steps that changed the line and how long matching took
+func debugValue(config *Config, matched string, preprocessed bool, header string, duration time.Duration) string {
+	debug := NewOrderedMap()
+	if matched == "" {
+		matched = "none"
+	}
+	debug.Set("pattern", matched)
+	if len(config.Preprocess) != 0 {
+		debug.Set("preprocess", strconv.FormatBool(preprocessed))
+	}
+	if config.glogSet {
+		if header == "" {
+			header = "none"
+		}
+		debug.Set("glog", header)
+	}
+	debug.Set("duration", duration.String())
+	return debug.ToLogfmt()
+}
+
+// merge static values and expand the templated ones
+func addFields(log *OrderedMap, add map[string]string, templated []string) {
+	if len(add) == 0 {
+		return
+	}
+	log.Merge(add)
+	for _, key := range templated {
+		log.values[key] = expandFields(add[key], log)
+	}
+}
+
+// ${field} references in add values, env vars were already expanded when loading the config
+var fieldReferenceRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+var hostname, _ = os.Hostname()
+
+// replace ${field} with the field of the log, the environment variable or HOSTNAME, otherwise empty
+func expandFields(value string, log *OrderedMap) string {
+	return fieldReferenceRegex.ReplaceAllStringFunc(value, func(reference string) string {
+		name := reference[2 : len(reference)-1]
+		if value, found := log.values[name]; found {
+			return value
+		}
+		if value, found := os.LookupEnv(name); found {
+			return value
+		}
+		if name == "HOSTNAME" {
+			return hostname
+		}
+		return ""
+	})
+}
+
+func renameAndRemoveFields(log *OrderedMap, rename map[string]string, remove []string) {
+	for from, to := range rename {
+		log.Rename(from, to)
+	}
+	for _, key := range remove {
+		log.Delete(key)
+	}
+}
+
+// evaluate condition against current fields and captures
+func matchesCondition(condition *vm.Program, log *OrderedMap, re *regexp.Regexp, match []string) bool {
+	env := make(map[string]string, len(log.values))
+	for k, v := range log.values {
+		env[k] = v
+	}
+	if re != nil {
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				env[name] = match[i]
+			}
+		}
+	}
+	result, err := expr.Run(condition, env)
+	return err == nil && result.(bool)
+}
+
+func redact(log *OrderedMap, config *Config) {
+	for _, key := range log.keys {
+		log.values[key] = redactValue(log.values[key], config)
+	}
+	if log.raw != nil {
+		raw := redactValue(*log.raw, config)
+		log.raw = &raw
+	}
+}
+
+func redactValue(value string, config *Config) string {
+	for _, redaction := range config.Redact {
+		value = redaction.regexParsed.ReplaceAllString(value, *redaction.Replace)
+	}
+	return value
+}
+
+// cut after max bytes without splitting characters and mark it with …
+func truncateValue(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max] + "…"
+}
+
+// avoid regex overhead when there is no escape character
+func stripAnsi(value string) string {
+	if strings.IndexByte(value, '\x1b') == -1 {
+		return value
+	}
+	return ansiRegex.ReplaceAllString(value, "")
+}
+
+func replaceFields(log *OrderedMap, replacements []Replacement) {
+	for _, replacement := range replacements {
+		for _, field := range replacement.Fields {
+			if value, found := log.values[field]; found {
+				log.values[field] = replacement.regexParsed.ReplaceAllString(value, replacement.Replace)
+			}
+		}
+	}
+}
+
+// labels from the pool, release them after reporting
+func metricLabels(log *OrderedMap, config *Config, ignoreMetricLabels []string) map[string]string {
+	labels := acquireLabels()
+
+	if config.AllowMetricLabels != nil {
+		// only use explicitly allowed labels
+		for _, l := range config.AllowMetricLabels {
+			if value, set := log.values[l]; set {
+				labels[l] = value
+			}
+		}
+	} else {
+		for k, v := range log.values {
+			labels[k] = v
+		}
+	}
+
+	// remove keys nobody should be using as metrics, but can get set accidentally via captures
+	delete(labels, config.MessageKey)
+	if config.DebugKey != "" {
+		delete(labels, config.DebugKey)
+	}
+	if config.timestampKeySet {
+		delete(labels, config.TimestampKey)
+	}
+
+	if config.Kubernetes != nil && !config.Kubernetes.MetricLabels {
+		for _, l := range config.Kubernetes.keys {
+			delete(labels, l)
+		}
+	}
+
+	if config.Split != nil {
+		delete(labels, config.Split.IdKey)
+		delete(labels, config.Split.IndexKey)
+	}
+
+	if config.Fingerprint != nil {
+		if !config.Fingerprint.MetricLabel {
+			delete(labels, config.Fingerprint.Key)
+		}
+		if config.Fingerprint.TemplateKey != "" {
+			delete(labels, config.Fingerprint.TemplateKey)
+		}
+	}
+
+	// remove explicitly ignored labels
+	for _, l := range ignoreMetricLabels {
+		delete(labels, l)
+	}
+
+	return labels
+}
+
+// TODO: this should ideally keep the ordering of the json keys
+func captureJson(config *Config, log *OrderedMap) {
+	jsonMap := make(map[string](interface{}))
+	err := json.Unmarshal([]byte(log.values[config.MessageKey]), &jsonMap)
+	if err != nil {
+		return
+	}
+
+	// we split up the message, so discard it
+	// TODO: deal with json that did not have a message by cleanly removing it
+	log.values[config.MessageKey] = ""
+
+	for k, v := range jsonMap {
+		// TODO: allow parsing through any json type by not using Sprintf
+		log.Set(k, fmt.Sprintf("%v", v))
+	}
+}
@@ -0,0 +1,127 @@
+package recycler
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replay sends the lines of a recorded file paced like they were logged or at a fixed rate,
+// to load test sinks and reproduce incidents
+type Replay struct {
+	Path  string
+	Speed float64 // 2 replays twice as fast
+	Rate  float64 // lines per second instead of pacing by timestamps
+	file  *os.File
+	first time.Time // timestamp of the first line with a timestamp
+	sent  int
+}
+
+// Speed of a replay like 2x or 0.5
+func parseSpeed(value string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("speed must be a positive number like 2x but was %v", value)
+	}
+	return speed, nil
+}
+
+func (r *Replay) Open() error {
+	var err error
+	r.file, err = os.Open(r.Path)
+	return err
+}
+
+// ReadLines sends lines when they are due, lines without timestamp are sent right after the previous line
+func (r *Replay) ReadLines(lines chan<- Line, config *Config) {
+	defer r.file.Close()
+	read := make(chan Line)
+	go func() {
+		readLines(r.file, "", read, config)
+		close(read)
+	}()
+
+	started := time.Now()
+	for line := range read {
+		if offset, found := r.offset(line, config); found {
+			if wait := time.Until(started.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		lines <- line
+	}
+}
+
+// time since the start of the replay when the line is due
+func (r *Replay) offset(line Line, config *Config) (time.Duration, bool) {
+	var offset time.Duration
+	if r.Rate != 0 {
+		offset = time.Duration(float64(r.sent) / r.Rate * float64(time.Second))
+	} else {
+		timestamp, found := config.TimestampParse.parse(line.Text, time.Now())
+		if !found {
+			return 0, false
+		}
+		if r.first.IsZero() {
+			r.first = timestamp
+		}
+		offset = timestamp.Sub(r.first)
+	}
+	r.sent++
+	return time.Duration(float64(offset) / r.Speed), true
+}
+
+// replay a recorded file through the configured pipeline including all sinks
+func runReplay(args []string) int {
+	set, flags := newFlagSet("logrecycler replay", "send the lines of a recorded file paced by their timestamps (see timestampParse) or at a fixed rate\n"+
+		"usage: logrecycler replay [-speed 2x] [-rate 100] [-config logrecycler.yaml] [-set key=value] file.log\n")
+	speed := set.String("speed", "1x", "Replay faster or slower like `2x`")
+	rate := set.Float64("rate", 0, "Lines per second instead of pacing by timestamps")
+
+	// flags can also come after the file like `replay file.log -speed 2x`
+	path := ""
+	for {
+		if code, done := parseFlags(set, args); done {
+			return code
+		}
+		if set.NArg() == 0 {
+			break
+		}
+		if path != "" {
+			set.Usage()
+			return exitFailure
+		}
+		path = set.Arg(0)
+		args = set.Args()[1:]
+	}
+	if path == "" {
+		set.Usage()
+		return exitFailure
+	}
+	replay := &Replay{Path: path, Rate: *rate}
+	var err error
+	if replay.Speed, err = parseSpeed(*speed); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+		return exitFailure
+	}
+	if replay.Rate < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: rate must not be negative but was %v\n", replay.Rate)
+		return exitFailure
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		return exitFailure
+	}
+	if replay.Rate == 0 && config.TimestampParse == nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error: replay needs timestampParse to be configured or -rate")
+		return exitFailure
+	}
+	if err := replay.Open(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+		return exitFailure
+	}
+	return pipeline(config, nil, replay)
+}
@@ -0,0 +1,161 @@
+package recycler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Retry sends again with exponential backoff and stops sending for a while when a sink keeps failing,
+// so an unavailable sink does not slow down everything else
+type Retry struct {
+	Attempts   *int          // retries after the first attempt failed (default 3)
+	Wait       time.Duration // before the first retry, doubled for every retry (default 1s)
+	MaxWait    time.Duration `yaml:"maxWait"`    // longest wait between retries (default 30s)
+	BreakAfter *int          `yaml:"breakAfter"` // stop sending after this many sends failed in a row, 0 to never stop (default 5)
+	BreakFor   time.Duration `yaml:"breakFor"`   // how long to stop sending (default 30s)
+	sink       string
+	lock       sync.Mutex
+	failures   int
+	openedAt   time.Time
+	openUntil  time.Time
+	openNanos  int64 // time the circuit was open before the current/last opening
+	retries    uint64
+	buffered   bool   // sends that fail are kept in a disk buffer, otherwise they are lost
+	dropped    uint64 // sends lost while the circuit was open
+}
+
+var errCircuitOpen = errors.New("not sending because of previous failures")
+
+// fill in defaults, sink is used in error messages
+func (r *Retry) setDefaults(sink string) {
+	r.sink = sink
+	if r.Attempts == nil {
+		attempts := 3
+		r.Attempts = &attempts
+	}
+	if r.Wait == 0 {
+		r.Wait = time.Second
+	}
+	if r.MaxWait == 0 {
+		r.MaxWait = 30 * time.Second
+	}
+	if r.BreakAfter == nil {
+		breakAfter := 5
+		r.BreakAfter = &breakAfter
+	}
+	if r.BreakFor == 0 {
+		r.BreakFor = 30 * time.Second
+	}
+}
+
+func (r *Retry) validate(location string) error {
+	if r.Attempts != nil && *r.Attempts < 0 {
+		return fmt.Errorf("%v.attempts must be 0 or more but was %d", location, *r.Attempts)
+	}
+	if r.BreakAfter != nil && *r.BreakAfter < 0 {
+		return fmt.Errorf("%v.breakAfter must be 0 or more but was %d", location, *r.BreakAfter)
+	}
+	return nil
+}
+
+// Do calls send until it succeeds, fails without asking for a retry or runs out of attempts,
+// returns if the failure was temporary so sending again later could work
+func (r *Retry) Do(send func() (bool, error)) (bool, error) {
+	if r.open(time.Now()) {
+		return true, errCircuitOpen
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := send()
+		if err == nil || !retry {
+			r.finished(false)
+			return false, err
+		}
+		if attempt >= *r.Attempts {
+			r.finished(true)
+			return true, err
+		}
+		atomic.AddUint64(&r.retries, 1)
+		time.Sleep(r.backoff(attempt))
+	}
+}
+
+// Send like Do and report failures, returns the error when sending again later could work
+func (r *Retry) Send(action string, send func() (bool, error)) error {
+	retry, err := r.Do(send)
+	if err != nil && err != errCircuitOpen {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v: %v\n", action, err.Error())
+	}
+	if err == errCircuitOpen && !r.buffered {
+		atomic.AddUint64(&r.dropped, 1)
+	}
+	if retry {
+		return err
+	}
+	return nil
+}
+
+// wait before the given retry
+func (r *Retry) backoff(attempt int) time.Duration {
+	wait := r.Wait
+	for i := 0; i < attempt && wait < r.MaxWait; i++ {
+		wait *= 2
+	}
+	return min(wait, r.MaxWait)
+}
+
+func (r *Retry) open(now time.Time) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return now.Before(r.openUntil)
+}
+
+// count failures in a row and open the circuit when there are too many
+func (r *Retry) finished(failed bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !failed {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	if *r.BreakAfter == 0 || r.failures < *r.BreakAfter {
+		return
+	}
+	now := time.Now()
+	r.openNanos += int64(r.openUntil.Sub(r.openedAt))
+	r.openedAt = now
+	r.openUntil = now.Add(r.BreakFor)
+	r.failures = 0
+	dropping := ""
+	if !r.buffered {
+		dropping = ", dropping logs since there is no buffer"
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Error: %v failed %d times in a row, not sending for %v%v\n", r.sink, *r.BreakAfter, r.BreakFor, dropping)
+}
+
+// Retries is how often sending was retried
+func (r *Retry) Retries() uint64 {
+	return atomic.LoadUint64(&r.retries)
+}
+
+// Dropped is how many sends were lost because the circuit was open and there is no buffer
+func (r *Retry) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// OpenSeconds is how long sending was stopped because of failures
+func (r *Retry) OpenSeconds() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	open := time.Duration(r.openNanos)
+	if now := time.Now(); now.Before(r.openUntil) {
+		open += now.Sub(r.openedAt)
+	} else {
+		open += r.openUntil.Sub(r.openedAt)
+	}
+	return open.Seconds()
+}
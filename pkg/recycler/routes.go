@@ -0,0 +1,67 @@
+package recycler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// Route sends logs matching the condition to the outputs, logs go to the outputs of all matching routes
+type Route struct {
+	When       string // https://expr-lang.org expression on fields, matches everything when empty
+	whenParsed *vm.Program
+	Outputs    []string
+}
+
+var outputNames = []string{"stdout", "file", "loki", "elasticsearch", "kafka", "splunk", "otlp", "slack"}
+
+func (c *Config) validateRoutes() error {
+	configured := map[string]bool{
+		"stdout":        true,
+		"file":          c.OutputFile != nil,
+		"loki":          c.Loki != nil,
+		"elasticsearch": c.Elasticsearch != nil,
+		"kafka":         c.Kafka != nil,
+		"splunk":        c.Splunk != nil,
+		"otlp":          c.Otlp != nil,
+		"slack":         c.Slack != nil,
+	}
+	for i := range c.Routes {
+		location := "routes[" + strconv.Itoa(i) + "]"
+		if c.Routes[i].When != "" {
+			program, err := compileCondition(c.Routes[i].When, location+".when")
+			if err != nil {
+				return err
+			}
+			c.Routes[i].whenParsed = program
+		}
+		for _, output := range c.Routes[i].Outputs {
+			enabled, found := configured[output]
+			if !found {
+				return fmt.Errorf("%v.outputs must be one of %v but was %v", location, strings.Join(outputNames, ", "), output)
+			}
+			if !enabled {
+				return fmt.Errorf("%v.outputs %v is not configured", location, output)
+			}
+		}
+	}
+	return nil
+}
+
+// outputs the log should be sent to, nil when there are no routes and it goes everywhere
+func routeOutputs(log *OrderedMap, config *Config) map[string]bool {
+	if len(config.Routes) == 0 {
+		return nil
+	}
+	outputs := map[string]bool{}
+	for _, route := range config.Routes {
+		if route.whenParsed == nil || matchesCondition(route.whenParsed, log, nil, nil) {
+			for _, output := range route.Outputs {
+				outputs[output] = true
+			}
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,59 @@
+package recycler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Slack posts formatted lines to an incoming webhook https://api.slack.com/messaging/webhooks
+type Slack struct {
+	Url       string
+	BatchSize int           `yaml:"batchSize"`
+	BatchWait time.Duration `yaml:"batchWait"`
+	Retry     *Retry
+	batcher   *Batcher[string]
+	client    *http.Client
+}
+
+func (s *Slack) Start() {
+	if s.BatchSize == 0 {
+		s.BatchSize = 20
+	}
+	if s.BatchWait == 0 {
+		s.BatchWait = time.Second // slack allows about 1 message per second
+	}
+	s.client = &http.Client{Timeout: 10 * time.Second}
+	s.batcher = NewBufferedBatcher(s.BatchSize, s.BatchWait, nil, s.send)
+}
+
+// Stop sends all remaining lines
+func (s *Slack) Stop() {
+	s.batcher.Stop()
+}
+
+// Push a formatted line, blocking when slack cannot keep up
+func (s *Slack) Push(line string) {
+	s.batcher.Add(line)
+}
+
+// one message per batch to avoid rate limits
+func (s *Slack) send(batch []string) error {
+	body := `{"text":` + jsonString(strings.Join(batch, "\n")) + `}`
+	return s.Retry.Send("posting to slack", func() (bool, error) {
+		response, err := s.client.Post(s.Url, "application/json", bytes.NewReader([]byte(body)))
+		if err != nil {
+			return true, err // untested section
+		}
+		_ = response.Body.Close()
+		if response.StatusCode == 429 || response.StatusCode >= 500 {
+			return true, fmt.Errorf("status %v", response.StatusCode)
+		}
+		if response.StatusCode >= 300 {
+			return false, fmt.Errorf("status %v", response.StatusCode)
+		}
+		return false, nil
+	})
+}
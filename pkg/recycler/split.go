@@ -0,0 +1,124 @@
+package recycler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Split turns a line with several concatenated events into one event per part
+type Split struct {
+	Regex       string // separator between events
+	regexParsed *regexp.Regexp
+	Json        bool   // elements of json arrays and concatenated json values like {...}{...}
+	IdKey       string `yaml:"idKey"`    // field with an id shared by all parts of a line, to correlate them downstream
+	IndexKey    string `yaml:"indexKey"` // field with the position of the part in the line, starting at 0
+}
+
+func (s *Split) validate() error {
+	if (s.Regex == "") == !s.Json {
+		return fmt.Errorf("split must set one of regex or json")
+	}
+	if s.Regex != "" {
+		var err error
+		if s.regexParsed, err = compileRegex(s.Regex, "split.regex"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parts of the text, nil when it is a single event
+func (s *Split) Parts(text string) []string {
+	var parts []string
+	if s.regexParsed != nil {
+		for _, part := range s.regexParsed.Split(text, -1) {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	} else {
+		parts = splitJson(text)
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
+
+// Lines of the parts, with id and index fields when configured
+func (s *Split) Lines(line Line, parts []string) []Line {
+	id := ""
+	if s.IdKey != "" {
+		id = newSplitId()
+	}
+	lines := make([]Line, len(parts))
+	for i, part := range parts {
+		lines[i] = line
+		lines[i].Text = part
+		if fields := s.fields(line, id, i); fields != nil {
+			lines[i].Fields = fields
+		}
+	}
+	return lines
+}
+
+// fields of the line with id and index of a part, nil when neither is configured
+func (s *Split) fields(line Line, id string, index int) *OrderedMap {
+	if s.IdKey == "" && s.IndexKey == "" {
+		return nil
+	}
+	fields := NewOrderedMap()
+	if line.Fields != nil {
+		for _, key := range line.Fields.keys {
+			fields.Set(key, line.Fields.values[key])
+		}
+	}
+	if s.IdKey != "" {
+		fields.Set(s.IdKey, id)
+	}
+	if s.IndexKey != "" {
+		fields.Set(s.IndexKey, strconv.Itoa(index))
+		fields.SetType(s.IndexKey, "int")
+	}
+	return fields
+}
+
+func newSplitId() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// array elements or concatenated values, strings are unquoted, nil when it is not valid json
+func splitJson(text string) []string {
+	trimmed := strings.TrimSpace(text)
+	var values []json.RawMessage
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &values); err != nil {
+			return nil
+		}
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		for decoder.More() {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return nil
+			}
+			values = append(values, value)
+		}
+	}
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		var unquoted string
+		if bytes.HasPrefix(value, []byte(`"`)) && json.Unmarshal(value, &unquoted) == nil {
+			parts[i] = unquoted
+		} else {
+			parts[i] = string(value)
+		}
+	}
+	return parts
+}
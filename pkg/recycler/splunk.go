@@ -0,0 +1,129 @@
+package recycler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Splunk sends logs in batches to the http event collector https://docs.splunk.com/Documentation/Splunk/latest/Data/HECRESTendpoints
+type Splunk struct {
+	Url              string // for example https://splunk:8088/services/collector/event
+	Token            string
+	Index            string // template like outputTemplate, splunk default when empty
+	indexParsed      *template.Template
+	Sourcetype       string // template like outputTemplate, splunk default when empty
+	sourcetypeParsed *template.Template
+	Gzip             bool
+	BatchSize        int           `yaml:"batchSize"`
+	BatchWait        time.Duration `yaml:"batchWait"`
+	Retries          *int          // shorthand for retry.attempts
+	Retry            *Retry
+	Buffer           *DiskBuffer
+	batcher          *Batcher[string]
+	client           *http.Client
+}
+
+func (s *Splunk) validate() error {
+	if s.Url == "" || s.Token == "" {
+		return fmt.Errorf("splunk.url and splunk.token must be set")
+	}
+	if err := validateBatchWait("splunk", s.BatchWait); err != nil {
+		return err
+	}
+	var err error
+	if s.indexParsed, err = parseFieldTemplate("splunk.index", s.Index); err != nil {
+		return err
+	}
+	if s.sourcetypeParsed, err = parseFieldTemplate("splunk.sourcetype", s.Sourcetype); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Splunk) Start() {
+	if s.BatchSize == 0 {
+		s.BatchSize = 100
+	}
+	if s.BatchWait == 0 {
+		s.BatchWait = time.Second
+	}
+	s.client = &http.Client{Timeout: 30 * time.Second}
+	s.batcher = NewBufferedBatcher(s.BatchSize, s.BatchWait, s.Buffer, s.send)
+}
+
+// Stop sends all remaining logs
+func (s *Splunk) Stop() {
+	s.batcher.Stop()
+}
+
+// Push a log, blocking when splunk cannot keep up
+func (s *Splunk) Push(log *OrderedMap) {
+	s.batcher.Add(s.event(log, time.Now()))
+}
+
+// events are concatenated json objects
+func (s *Splunk) event(log *OrderedMap, now time.Time) string {
+	event := `{"time":` + strconv.FormatFloat(float64(now.UnixMilli())/1000, 'f', 3, 64)
+	if index := executeFieldTemplate(s.indexParsed, log); index != "" {
+		event += `,"index":` + jsonString(index)
+	}
+	if sourcetype := executeFieldTemplate(s.sourcetypeParsed, log); sourcetype != "" {
+		event += `,"sourcetype":` + jsonString(sourcetype)
+	}
+	return event + `,"event":` + log.ToJson() + "}"
+}
+
+func (s *Splunk) send(batch []string) error {
+	var body bytes.Buffer
+	if s.Gzip {
+		writer := gzip.NewWriter(&body)
+		for _, event := range batch {
+			_, _ = writer.Write([]byte(event + "\n"))
+		}
+		_ = writer.Close()
+	} else {
+		for _, event := range batch {
+			body.WriteString(event + "\n")
+		}
+	}
+
+	return s.Retry.Send("sending to splunk", func() (bool, error) { return s.post(body.Bytes()) })
+}
+
+// returns if the request should be retried
+func (s *Splunk) post(body []byte) (bool, error) {
+	request, err := http.NewRequest("POST", s.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err // untested section
+	}
+	request.Header.Set("Authorization", "Splunk "+s.Token)
+	request.Header.Set("Content-Type", "application/json")
+	if s.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return true, err // untested section
+	}
+
+	if response.StatusCode == 429 || response.StatusCode >= 500 {
+		return true, fmt.Errorf("status %v", response.StatusCode)
+	}
+	if response.StatusCode >= 300 {
+		return false, fmt.Errorf("status %v: %v", response.StatusCode, string(content))
+	}
+	return false, nil
+}
@@ -0,0 +1,71 @@
+package recycler
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats summarizes a running logrecycler for SIGUSR1, to see what a live sidecar is doing without metrics
+type Stats struct {
+	started  time.Time
+	lines    uint64
+	logs     uint64
+	lock     sync.Mutex
+	last     time.Time // of the previous report, to show current throughput
+	lastRead uint64
+}
+
+func NewStats() *Stats {
+	now := time.Now()
+	return &Stats{started: now, last: now}
+}
+
+// Processed counts a line and how many logs it resulted in
+func (s *Stats) Processed(logs int) {
+	atomic.AddUint64(&s.lines, 1)
+	atomic.AddUint64(&s.logs, uint64(logs))
+}
+
+// Report a logfmt line with throughput since the previous report and memory usage, followed by pattern stats
+func (s *Stats) Report(patternStats *PatternStats) string {
+	s.lock.Lock()
+	now := time.Now()
+	lines := atomic.LoadUint64(&s.lines)
+	perSecond := float64(lines-s.lastRead) / max(now.Sub(s.last).Seconds(), 0.001)
+	s.last = now
+	s.lastRead = lines
+	s.lock.Unlock()
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	line := NewOrderedMap()
+	line.Set("uptime", now.Sub(s.started).Round(time.Second).String())
+	line.Set("lines", strconv.FormatUint(lines, 10))
+	line.Set("logs", strconv.FormatUint(atomic.LoadUint64(&s.logs), 10))
+	line.Set("lines_per_second", strconv.FormatFloat(perSecond, 'f', 1, 64))
+	line.Set("heap", formatBytes(memory.HeapAlloc))
+	line.Set("memory", formatBytes(memory.Sys))
+	line.Set("goroutines", strconv.Itoa(runtime.NumGoroutine()))
+	line.Set("gc", strconv.FormatUint(uint64(memory.NumGC), 10))
+	return line.ToLogfmt() + "\n" + patternStats.Report()
+}
+
+// bytes as B, KB, MB or GB
+func formatBytes(bytes uint64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	return fmt.Sprintf("%.1f%v", value, units[unit])
+}
@@ -0,0 +1,239 @@
+package recycler
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+type Statsd struct {
+	Address       string
+	Socket        string // unix socket path, used instead of address
+	Metric        string
+	SampleRate    float64                       `yaml:"sampleRate"`    // only send this fraction of increments, the agent scales them back up
+	MaxPacketSize int                           `yaml:"maxPacketSize"` // bytes per buffered payload
+	FlushInterval time.Duration                 `yaml:"flushInterval"` // how often buffered payloads are sent
+	Tags          []string                      // only send these labels as tags
+	client        atomic.Pointer[statsd.Client] // nil until connected
+	dropped       uint64
+	stop          chan struct{}
+	done          sync.WaitGroup
+}
+
+// how long to wait before connecting again when the agent was not reachable
+var statsdReconnectWait = 5 * time.Second
+
+// PatternStatsd reports matches of a pattern as their own metric instead of the global counter
+type PatternStatsd struct {
+	Metric string // default statsd.metric
+	Type   string // count, gauge, timing, histogram or distribution (default count)
+	Field  string // captured value to report, count adds it instead of 1
+}
+
+func (p *PatternStatsd) validate(location string, statsd *Statsd) error {
+	if statsd == nil {
+		return fmt.Errorf("%v requires statsd to be configured", location)
+	}
+	if p.Metric == "" {
+		p.Metric = statsd.Metric
+	}
+	switch p.Type {
+	case "":
+		p.Type = "count"
+	case "count":
+	case "gauge", "timing", "histogram", "distribution":
+		if p.Field == "" {
+			return fmt.Errorf("%v.field must be set for %v", location, p.Type)
+		}
+	default:
+		return fmt.Errorf("%v.type must be count, gauge, timing, histogram or distribution but was %v", location, p.Type)
+	}
+	return nil
+}
+
+// StatsdEvent posts a DataDog event for each match of a pattern, for example for panics
+type StatsdEvent struct {
+	Title                string // template like outputTemplate (default pattern name or message)
+	titleParsed          *template.Template
+	AlertType            string `yaml:"alertType"`      // info, warning, error or success (default info)
+	AggregationKey       string `yaml:"aggregationKey"` // template, events with the same key are grouped
+	aggregationKeyParsed *template.Template
+	Priority             string // normal or low (default normal)
+}
+
+func (e *StatsdEvent) validate(location string, statsd *Statsd, pattern *Pattern) error {
+	if statsd == nil {
+		return fmt.Errorf("%v requires statsd to be configured", location)
+	}
+	if e.AlertType != "" && !contains([]string{"info", "warning", "error", "success"}, e.AlertType) {
+		return fmt.Errorf("%v.alertType must be info, warning, error or success but was %v", location, e.AlertType)
+	}
+	if e.Priority != "" && e.Priority != "normal" && e.Priority != "low" {
+		return fmt.Errorf("%v.priority must be normal or low but was %v", location, e.Priority)
+	}
+	if e.Title == "" && pattern.Name != "" {
+		e.Title = pattern.Name
+	}
+	var err error
+	if e.titleParsed, err = parseFieldTemplate(location+".title", e.Title); err != nil {
+		return err
+	}
+	if e.aggregationKeyParsed, err = parseFieldTemplate(location+".aggregationKey", e.AggregationKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Start connects in the background when the agent is not reachable yet, for example while its dns is not resolvable,
+// metrics are dropped until then so logging never fails because of statsd
+func (s *Statsd) Start() {
+	err := s.connect()
+	if err == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Error: statsd: %v, connecting in the background\n", err.Error())
+
+	s.stop = make(chan struct{})
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(statsdReconnectWait)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.connect() == nil {
+					return
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Statsd) connect() error {
+	address := s.Address
+	if s.Socket != "" {
+		address = statsd.UnixAddressPrefix + s.Socket
+	}
+
+	options := []statsd.Option{}
+	if s.MaxPacketSize != 0 {
+		options = append(options, statsd.WithMaxBytesPerPayload(s.MaxPacketSize))
+	}
+	if s.FlushInterval != 0 {
+		options = append(options, statsd.WithBufferFlushInterval(s.FlushInterval))
+	}
+
+	client, err := statsd.New(address, options...)
+	if err != nil {
+		return err
+	}
+	s.client.Store(client)
+	return nil
+}
+
+func (s *Statsd) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.done.Wait()
+	}
+	if client := s.client.Load(); client != nil {
+		client.Close()
+	}
+}
+
+// connected client or nil when the metric has to be dropped
+func (s *Statsd) connected() *statsd.Client {
+	client := s.client.Load()
+	if client == nil {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return client
+}
+
+// Dropped is how many metrics and events were not sent because statsd was not connected
+func (s *Statsd) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// send everything except message and the skipped key
+func (s *Statsd) tags(m map[string]string, skip string) *[]string {
+	tags := []string{}
+	for k, v := range m {
+		if k == skip || s.Tags != nil && !contains(s.Tags, k) {
+			continue
+		}
+		tags = append(tags, k+":"+v)
+	}
+
+	return &tags
+}
+
+func (s *Statsd) Inc(m map[string]string) {
+	client := s.connected()
+	if client == nil {
+		return
+	}
+	client.Incr(s.Metric, *s.tags(m, ""), s.SampleRate)
+}
+
+// Report a pattern metric, the field is not used as tag and logs without a numeric field are not reported
+// timings are in milliseconds
+func (s *Statsd) Report(metric *PatternStatsd, m map[string]string) {
+	value := 1.0
+	if metric.Field != "" {
+		var err error
+		if value, err = strconv.ParseFloat(m[metric.Field], 64); err != nil {
+			return
+		}
+	}
+	client := s.connected()
+	if client == nil {
+		return
+	}
+	tags := *s.tags(m, metric.Field)
+	switch metric.Type {
+	case "count":
+		if metric.Field == "" {
+			client.Incr(metric.Metric, tags, s.SampleRate)
+		} else {
+			client.Count(metric.Metric, int64(value), tags, s.SampleRate)
+		}
+	case "gauge":
+		client.Gauge(metric.Metric, value, tags, s.SampleRate)
+	case "timing":
+		client.TimeInMilliseconds(metric.Metric, value, tags, s.SampleRate)
+	case "histogram":
+		client.Histogram(metric.Metric, value, tags, s.SampleRate)
+	case "distribution":
+		client.Distribution(metric.Metric, value, tags, s.SampleRate)
+	}
+}
+
+// Event posts the log as event with the labels as tags, events are not sampled
+func (s *Statsd) Event(event *StatsdEvent, log *OrderedMap, message string, m map[string]string) {
+	client := s.connected()
+	if client == nil {
+		return
+	}
+	title := executeFieldTemplate(event.titleParsed, log)
+	if title == "" {
+		title = message
+	}
+	client.Event(&statsd.Event{
+		Title:          title,
+		Text:           message,
+		AlertType:      statsd.EventAlertType(event.AlertType),
+		AggregationKey: executeFieldTemplate(event.aggregationKeyParsed, log),
+		Priority:       statsd.EventPriority(event.Priority),
+		Tags:           *s.tags(m, ""),
+	})
+}
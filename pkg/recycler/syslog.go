@@ -0,0 +1,74 @@
+package recycler
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// <34>Oct 11 22:14:15 mymachine su[123]: message (priority is optional since rsyslog files omit it)
+var rfc3164Regex = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d) (\S+) ([^\s:\[]+)(?:\[(\d+)\])?: ?`)
+
+// <34>1 2003-10-11T22:14:15.003Z mymachine su 123 ID47 [structured data] message
+var rfc5424Regex = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) (\S+) (?:-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: \x{FEFF}?|$)`)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"FATAL", "FATAL", "CRITICAL", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+
+// strip rfc3164 or rfc5424 syslog headers and capture priority, timestamp, host, tag and pid
+func captureSyslog(config *Config, log *OrderedMap) {
+	message := log.values[config.MessageKey]
+	if match := rfc5424Regex.FindStringSubmatch(message); match != nil {
+		log.values[config.MessageKey] = message[len(match[0]):]
+		captureSyslogPriority(config, match[1], log)
+		if config.timestampKeySet && match[2] != "-" {
+			if parsed, err := time.Parse(time.RFC3339Nano, match[2]); err == nil {
+				log.values[config.TimestampKey] = parsed.Format(timeFormat)
+			}
+		}
+		setSyslogField(log, "host", match[3])
+		setSyslogField(log, "tag", match[4])
+		setSyslogField(log, "pid", match[5])
+		setSyslogField(log, "msgid", match[6])
+	} else if match := rfc3164Regex.FindStringSubmatch(message); match != nil {
+		log.values[config.MessageKey] = message[len(match[0]):]
+		captureSyslogPriority(config, match[1], log)
+		if config.timestampKeySet {
+			// year is not part of the header
+			if parsed, err := time.Parse(time.Stamp, match[2]); err == nil {
+				parsed = parsed.AddDate(time.Now().Year(), 0, 0)
+				log.values[config.TimestampKey] = parsed.Format(timeFormat)
+			}
+		}
+		setSyslogField(log, "host", match[3])
+		setSyslogField(log, "tag", match[4])
+		setSyslogField(log, "pid", match[5])
+	}
+}
+
+// priority is facility * 8 + severity
+func captureSyslogPriority(config *Config, priority string, log *OrderedMap) {
+	if priority == "" {
+		return
+	}
+	value, err := strconv.Atoi(priority)
+	if err != nil || value/8 >= len(syslogFacilities) {
+		return
+	}
+	log.Set("facility", syslogFacilities[value/8])
+	if config.levelKeySet {
+		log.values[config.LevelKey] = syslogSeverities[value%8]
+	}
+}
+
+// "-" is the rfc5424 nil value
+func setSyslogField(log *OrderedMap, key string, value string) {
+	if value != "" && value != "-" {
+		log.Set(key, value)
+	}
+}
@@ -0,0 +1,160 @@
+package recycler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Telemetry counts what logrecycler itself does, to monitor it like any other service
+type Telemetry struct {
+	linesRead      uint64
+	linesEmitted   uint64
+	linesDiscarded uint64
+	linesUnmatched uint64
+	bytesRead      uint64
+	bytesWritten   uint64
+	readErrors     uint64
+	processedNanos uint64
+	histogram      prometheus.Histogram // set when reporting to prometheus
+	reported       map[string]uint64    // last values sent to statsd
+	stop           chan struct{}
+	done           sync.WaitGroup
+}
+
+// how often counts are sent to statsd
+var telemetryInterval = 10 * time.Second
+
+func NewTelemetry() *Telemetry {
+	return &Telemetry{reported: map[string]uint64{}}
+}
+
+// Processed counts a line that was read and how many logs it resulted in
+func (t *Telemetry) Processed(line Line, logs int, duration time.Duration) {
+	atomic.AddUint64(&t.linesRead, 1)
+	atomic.AddUint64(&t.bytesRead, uint64(len(line.Text)+1))
+	atomic.AddUint64(&t.processedNanos, uint64(duration))
+	if logs == 0 {
+		atomic.AddUint64(&t.linesDiscarded, 1)
+	}
+	if t.histogram != nil {
+		t.histogram.Observe(duration.Seconds())
+	}
+}
+
+// Emitted counts a log written to stdout or stderr
+func (t *Telemetry) Emitted(line string) {
+	atomic.AddUint64(&t.linesEmitted, 1)
+	atomic.AddUint64(&t.bytesWritten, uint64(len(line)+1))
+}
+
+func (t *Telemetry) Unmatched() {
+	atomic.AddUint64(&t.linesUnmatched, 1)
+}
+
+func (t *Telemetry) ReadError() {
+	atomic.AddUint64(&t.readErrors, 1)
+}
+
+// counters by metric name
+func (t *Telemetry) counters() map[string]*uint64 {
+	return map[string]*uint64{
+		"lines_read":      &t.linesRead,
+		"lines_emitted":   &t.linesEmitted,
+		"lines_discarded": &t.linesDiscarded,
+		"lines_unmatched": &t.linesUnmatched,
+		"bytes_read":      &t.bytesRead,
+		"bytes_written":   &t.bytesWritten,
+		"read_errors":     &t.readErrors,
+	}
+}
+
+var telemetryHelp = map[string]string{
+	"lines_read":      "Total number of lines read",
+	"lines_emitted":   "Total number of logs written to stdout or stderr",
+	"lines_discarded": "Total number of lines that resulted in no log",
+	"lines_unmatched": "Total number of lines that matched no pattern",
+	"bytes_read":      "Total number of bytes read",
+	"bytes_written":   "Total number of bytes written to stdout or stderr",
+	"read_errors":     "Total number of errors while reading input",
+}
+
+// register counters and the processing time histogram,
+// unmatched lines are already reported as logrecycler_unmatched_total
+func (t *Telemetry) register(registerer prometheus.Registerer, labels prometheus.Labels) error {
+	for name, counter := range t.counters() {
+		if name == "lines_unmatched" {
+			continue
+		}
+		counter := counter
+		err := registerer.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "logrecycler_" + name + "_total",
+			Help:        telemetryHelp[name],
+			ConstLabels: labels,
+		}, func() float64 { return float64(atomic.LoadUint64(counter)) }))
+		if err != nil {
+			return err
+		}
+	}
+	t.histogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "logrecycler_processing_seconds",
+		Help:        "Time it took to process a line",
+		ConstLabels: labels,
+		Buckets:     []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05},
+	})
+	return registerer.Register(t.histogram)
+}
+
+// Start sending the counts to statsd periodically
+func (t *Telemetry) Start(statsd *Statsd) {
+	t.stop = make(chan struct{})
+	t.done.Add(1)
+	go func() {
+		defer t.done.Done()
+		ticker := time.NewTicker(telemetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.report(statsd)
+			case <-t.stop:
+				t.report(statsd)
+				return
+			}
+		}
+	}()
+}
+
+func (t *Telemetry) Stop() {
+	if t.stop != nil {
+		close(t.stop)
+		t.done.Wait()
+	}
+}
+
+// send what changed since the last report and the average processing time
+func (t *Telemetry) report(statsd *Statsd) {
+	client := statsd.connected()
+	if client == nil {
+		return
+	}
+	counters := t.counters()
+	for _, name := range sortedMapKeys(counters) {
+		value := atomic.LoadUint64(counters[name])
+		if delta := value - t.reported[name]; delta != 0 {
+			_ = client.Count("logrecycler."+name, int64(delta), nil, 1)
+		}
+		t.reported[name] = value
+	}
+
+	nanos := atomic.LoadUint64(&t.processedNanos)
+	lines := t.reported["lines_read"] - t.reported["processed_lines"]
+	if lines != 0 {
+		average := time.Duration((nanos - t.reported["processed_nanos"]) / lines)
+		_ = client.Gauge("logrecycler.processing_seconds", average.Seconds(), nil, 1)
+	}
+	t.reported["processed_nanos"] = nanos
+	t.reported["processed_lines"] = t.reported["lines_read"]
+}
@@ -0,0 +1,94 @@
+package recycler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// TimestampParse replaces the processing time with the time found in the line
+type TimestampParse struct {
+	Regex       string // first capture is the time
+	regexParsed *regexp.Regexp
+	Layouts     []string // go layouts like 2006-01-02 15:04:05 or RFC3339, unix, unixMilli
+	Location    string   // for layouts without zone (default UTC)
+	location    *time.Location
+}
+
+var namedTimeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"Stamp":       time.Stamp,
+	"DateTime":    time.DateTime,
+	"StampMilli":  time.StampMilli,
+	"StampMicro":  time.StampMicro,
+	"StampNano":   time.StampNano,
+	"RFC3339Nano": time.RFC3339Nano,
+}
+
+func (t *TimestampParse) validate(config *Config) error {
+	if !config.timestampKeySet {
+		return fmt.Errorf("timestampParse requires timestampKey to be set")
+	}
+	if t.Regex == "" || len(t.Layouts) == 0 {
+		return fmt.Errorf("timestampParse.regex and timestampParse.layouts must be set")
+	}
+	var err error
+	if t.regexParsed, err = compileRegex(t.Regex, "timestampParse.regex"); err != nil {
+		return err
+	}
+	if t.regexParsed.NumSubexp() == 0 {
+		return fmt.Errorf("timestampParse.regex needs a capture group")
+	}
+	for i, layout := range t.Layouts {
+		if named, found := namedTimeLayouts[layout]; found {
+			t.Layouts[i] = named
+		}
+	}
+
+	if t.location, err = time.LoadLocation(t.Location); err != nil {
+		return fmt.Errorf("timestampParse.location: %v", err.Error())
+	}
+	return nil
+}
+
+// time from the line, false when not found or not parseable
+func (t *TimestampParse) parse(line string, now time.Time) (time.Time, bool) {
+	match := t.regexParsed.FindStringSubmatch(line)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := match[1]
+
+	for _, layout := range t.Layouts {
+		switch layout {
+		case "unix", "unixMilli":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if layout == "unixMilli" {
+				number /= 1000
+			}
+			return time.Unix(0, int64(number*float64(time.Second))).In(t.location), true
+		default:
+			parsed, err := time.ParseInLocation(layout, value, t.location)
+			if err != nil {
+				continue
+			}
+			// layouts without year
+			if parsed.Year() == 0 {
+				parsed = parsed.AddDate(now.Year(), 0, 0)
+			}
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
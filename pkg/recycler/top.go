@@ -0,0 +1,134 @@
+package recycler
+
+import (
+	"container/heap"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Top counts the most frequent messages with ids and numbers masked, to find which logs to silence at the source,
+// reported at /debug/top and on SIGUSR1
+type Top struct {
+	Count    int // messages to report (default 10 or capacity)
+	Capacity int // distinct messages to track, the least frequent is replaced when full (default 1000)
+	lock     sync.Mutex
+	entries  map[string]*topEntry
+	least    topHeap // least frequent first, so replacing is cheap when full
+}
+
+type topEntry struct {
+	template string
+	count    int
+	index    int // in the heap
+}
+
+// min-heap of entries by count, for container/heap
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *topHeap) Push(x any) {
+	entry := x.(*topEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *topHeap) Pop() any {
+	// untested section
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+var (
+	topUuidRegex   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	topHexRegex    = regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|[0-9a-fA-F]*\d[0-9a-fA-F]*)\b`)
+	topNumberRegex = regexp.MustCompile(`\b\d+`) // not in words like ec2
+)
+
+func (t *Top) validate() error {
+	if t.Capacity == 0 {
+		t.Capacity = 1000
+	}
+	if t.Count == 0 {
+		t.Count = min(10, t.Capacity)
+	}
+	if t.Count < 0 || t.Capacity < t.Count {
+		return fmt.Errorf("top.count must be between 1 and top.capacity (%d) but was %d", t.Capacity, t.Count)
+	}
+	t.entries = map[string]*topEntry{}
+	return nil
+}
+
+// Add counts the message, new messages replace the least frequent one when at capacity but start with its count
+// so they are not replaced right away (space saving)
+func (t *Top) Add(message string) {
+	template := maskMessage(message)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entry, found := t.entries[template]
+	switch {
+	case found:
+	case len(t.entries) >= t.Capacity:
+		entry = t.least[0]
+		delete(t.entries, entry.template)
+		entry.template = template
+		t.entries[template] = entry
+	default:
+		entry = &topEntry{template: template}
+		t.entries[template] = entry
+		heap.Push(&t.least, entry)
+	}
+	entry.count++
+	heap.Fix(&t.least, entry.index)
+}
+
+// Report a logfmt line per message, most frequent first
+func (t *Top) Report() string {
+	t.lock.Lock()
+	templates := make([]string, 0, len(t.entries))
+	counts := make(map[string]int, len(t.entries))
+	for template, entry := range t.entries {
+		templates = append(templates, template)
+		counts[template] = entry.count
+	}
+	t.lock.Unlock()
+
+	sort.Slice(templates, func(i, j int) bool {
+		if counts[templates[i]] != counts[templates[j]] {
+			return counts[templates[i]] > counts[templates[j]]
+		}
+		return templates[i] < templates[j]
+	})
+	var report strings.Builder
+	for i, template := range templates {
+		if i == t.Count {
+			break
+		}
+		line := NewOrderedMap()
+		line.Set("count", strconv.Itoa(counts[template]))
+		line.Set("message", template)
+		report.WriteString(line.ToLogfmt() + "\n")
+	}
+	return report.String()
+}
+
+// replace uuids, hex ids and numbers so messages that only differ in them are counted together
+func maskMessage(message string) string {
+	message = topUuidRegex.ReplaceAllString(message, "<uuid>")
+	message = topHexRegex.ReplaceAllStringFunc(message, func(word string) string {
+		if strings.HasPrefix(word, "0x") || len(word) >= 8 && strings.ContainsAny(word, "abcdefABCDEF") {
+			return "<hex>"
+		}
+		return word // short words like ec2 and numbers
+	})
+	return topNumberRegex.ReplaceAllString(message, "<n>")
+}
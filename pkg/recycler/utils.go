@@ -1,14 +1,17 @@
-package main
+package recycler
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/template"
 )
 
 // https://www.golangprograms.com/remove-duplicate-values-from-slice.html
@@ -35,6 +38,15 @@ func removeElement(haystack []string, needle string) []string {
 	return clean
 }
 
+func contains(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
 // split an array of strings when a given delimiter is found
 func splitArrayOn(arr []string, delimiter string) ([]string, []string) {
 	for i, item := range arr {
@@ -45,6 +57,16 @@ func splitArrayOn(arr []string, delimiter string) ([]string, []string) {
 	return arr, nil
 }
 
+// unique key for a set of labels
+func labelsKey(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for k, v := range labels {
+		parts = append(parts, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
 // https://stackoverflow.com/questions/21362950/getting-a-slice-of-keys-from-a-map
 func keys(mymap map[string]string) []string {
 	keys := make([]string, 0, len(mymap))
@@ -54,20 +76,13 @@ func keys(mymap map[string]string) []string {
 	return keys
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e) // untested section
-	}
-}
-
-func helpfulMustCompile(expr string, location string) *regexp.Regexp {
+// regex with the config location in its error
+func compileRegex(expr string, location string) (*regexp.Regexp, error) {
 	compiled, err := regexp.Compile(expr)
 	if err != nil {
-		// untested section
-		_, _ = fmt.Fprintf(os.Stderr, "Error: regular expression from "+location+": "+err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("%v: %v", location, err)
 	}
-	return compiled
+	return compiled, nil
 }
 
 func addCaptureNames(re *regexp.Regexp, labels *[]string) {
@@ -78,52 +93,62 @@ func addCaptureNames(re *regexp.Regexp, labels *[]string) {
 	}
 }
 
-// https://stackoverflow.com/questions/39993688/are-golang-slices-passed-by-value
-func isPipingToStdin() bool {
-	stat, _ := os.Stdin.Stat()
-	return (stat.Mode() & os.ModeCharDevice) == 0
+// template that renders fields of a log, missing fields are empty, nil when text is empty
+func parseFieldTemplate(location string, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New(location).Option("missingkey=zero").Parse(text)
 }
 
-// ReaderChannel is an io.Reader used to stream command output to the log processor
-type ReaderChannel struct {
-	channel chan ([]byte)
+func executeFieldTemplate(t *template.Template, log *OrderedMap) string {
+	if t == nil {
+		return ""
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, log.values); err != nil {
+		return "" // untested section
+	}
+	return result.String()
 }
 
-func (b ReaderChannel) Read(p []byte) (n int, err error) {
-	msg, open := <-b.channel
-	if open {
-		copy(p, msg)
-		return len(msg), nil
-	} else {
-		return 0, io.EOF
-	}
+// https://stackoverflow.com/questions/39993688/are-golang-slices-passed-by-value
+func isPipingToStdin() bool {
+	stat, _ := os.Stdin.Stat()
+	return (stat.Mode() & os.ModeCharDevice) == 0
 }
 
-// executeCommand executes a shell command and returns a reader from the combined stdout and stderr + exit code channel
-func executeCommand(command []string) (io.Reader, chan (int), error) {
+// executeCommand executes a shell command and returns readers for its stdout and stderr + exit code channel
+// readers need to be closed after reading
+func executeCommand(command []string) (*os.File, *os.File, chan (int), error) {
 	cmd := exec.Command(command[0], command[1:]...)
 	exit := make(chan int)
-	output := ReaderChannel{make(chan []byte)}
 
-	// Send all output into a pipe
-	pipeReader, pipeWriter, err := os.Pipe()
+	// Send output into pipes, so we can stream it
+	stdoutReader, stdoutWriter, err := os.Pipe()
 	if err != nil {
 		// untested section
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	cmd.Stdout = pipeWriter
-	cmd.Stderr = pipeWriter
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		// untested section
+		return nil, nil, nil, err
+	}
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
 
 	// Start the command
 	err = cmd.Start()
 	if err != nil {
 		// untested section
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	// Pass on any signal, so the logrecycler behaves like the command it wraps
+	// Pass on any signal, so the logrecycler behaves like the command it wraps,
+	// except SIGUSR1 which dumps stats and would kill commands that do not handle it
 	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR2, syscall.SIGHUP)
 	go func() {
 		s, open := <-signalChannel
 		if open {
@@ -132,23 +157,15 @@ func executeCommand(command []string) (io.Reader, chan (int), error) {
 		}
 	}()
 
-	// Stream the output to the buffer, to be consumed by the log parser
-	go func() {
-		scanner := bufio.NewScanner(pipeReader)
-		for scanner.Scan() {
-			output.channel <- append(scanner.Bytes(), '\n')
-		}
-		close(output.channel)
-	}()
-
 	// Wait for the command to finish and store the exit code
 	go func() {
 		_ = cmd.Wait()
-		_ = pipeReader.Close() // make scanner stop
-		_ = pipeWriter.Close()
+		_ = stdoutWriter.Close() // make readers stop once they read all remaining output
+		_ = stderrWriter.Close()
+		signal.Stop(signalChannel)
 		close(signalChannel) // make sure exiting the program does not re-signal ourselves
 		exit <- cmd.ProcessState.ExitCode()
 	}()
 
-	return output, exit, nil
+	return stdoutReader, stderrReader, exit, nil
 }
@@ -0,0 +1,35 @@
+package recycler
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// set via -ldflags "-X github.com/grosser/logrecycler/pkg/recycler.Version=v1.2.3 ..." by release action
+var (
+	Version   = "master"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// version with commit, build date and go version, commit and build date fall back to what go build embedded
+func versionInfo() string {
+	commit, built := Commit, BuildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "":
+				commit = setting.Value
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return Version + " commit=" + commit + " built=" + built + " go=" + runtime.Version()
+}
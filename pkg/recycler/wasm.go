@@ -0,0 +1,163 @@
+package recycler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Wasm runs each log through a WebAssembly module that can change or drop it,
+// so sandboxed custom logic can be distributed as a single file alongside the config
+//
+// the module exports memory, allocate(size i32) -> ptr i32 and filter(ptr i32, size i32) -> i64,
+// filter gets the log as json and returns -1 to drop it, 0 to keep it as is
+// or ptr << 32 | size of a json object with fields to merge (null removes a field),
+// the allocated buffer is reused for the next logs and given to deallocate(ptr i32, size i32) when exported and a bigger one is needed
+type Wasm struct {
+	Module     string        // path to the .wasm file
+	Timeout    time.Duration // to wait for filter, the module is restarted after (default 100ms)
+	lock       sync.Mutex
+	runtime    wazero.Runtime
+	compiled   wazero.CompiledModule
+	module     api.Module // nil after it was stopped because of a timeout
+	memory     api.Memory
+	allocate   api.Function
+	deallocate api.Function // optional
+	filter     api.Function
+	buffer     uint32 // reused for requests
+	capacity   uint32
+}
+
+func (w *Wasm) validate() error {
+	if w.Module == "" {
+		return fmt.Errorf("wasm.module must be set")
+	}
+	if w.Timeout == 0 {
+		w.Timeout = 100 * time.Millisecond
+	}
+	content, err := os.ReadFile(w.Module)
+	if err != nil {
+		return fmt.Errorf("wasm.module: %v", err)
+	}
+
+	ctx := context.Background()
+	// so a module that does not return in time can be stopped
+	w.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, w.runtime) // so modules compiled for wasi can be loaded
+	if w.compiled, err = w.runtime.CompileModule(ctx, content); err != nil {
+		_ = w.runtime.Close(ctx)
+		return fmt.Errorf("wasm.module: %v", err)
+	}
+	if err := w.instantiate(); err != nil {
+		_ = w.runtime.Close(ctx)
+		return err
+	}
+	return nil
+}
+
+// start a fresh instance of the module, with new memory
+func (w *Wasm) instantiate() error {
+	ctx := context.Background()
+	module, err := w.runtime.InstantiateModule(ctx, w.compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return fmt.Errorf("wasm.module: %v", err)
+	}
+	w.memory = module.Memory()
+	w.allocate = module.ExportedFunction("allocate")
+	w.deallocate = module.ExportedFunction("deallocate")
+	w.filter = module.ExportedFunction("filter")
+	if w.memory == nil || w.allocate == nil || w.filter == nil {
+		_ = module.Close(ctx)
+		return fmt.Errorf("wasm.module must export memory, allocate and filter")
+	}
+	w.module = module
+	w.buffer, w.capacity = 0, 0
+	return nil
+}
+
+// Apply the module to the log, false when it should be dropped, the log is kept as is when the module fails
+func (w *Wasm) Apply(log *OrderedMap) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	response, keep, err := w.call([]byte(log.ToJson()))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: wasm: %v\n", err.Error())
+		return true
+	}
+	if len(response) == 0 {
+		return keep
+	}
+	if err := mergeFilterResponse(log, response); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: wasm: parsing %q: %v\n", response, err.Error())
+	}
+	return true
+}
+
+func (w *Wasm) call(request []byte) ([]byte, bool, error) {
+	if w.module == nil {
+		if err := w.instantiate(); err != nil {
+			return nil, true, err // untested section
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.Timeout)
+	defer cancel()
+	if err := w.reserve(ctx, uint32(len(request))); err != nil {
+		return nil, true, w.failed(err)
+	}
+	if !w.memory.Write(w.buffer, request) {
+		return nil, true, fmt.Errorf("allocate returned %d which is out of memory", w.buffer)
+	}
+	results, err := w.filter.Call(ctx, uint64(w.buffer), uint64(len(request)))
+	if err != nil {
+		return nil, true, w.failed(err)
+	}
+	result := int64(results[0])
+	if result == -1 {
+		return nil, false, nil
+	}
+	ptr, size := uint32(uint64(result)>>32), uint32(result)
+	if size == 0 {
+		return nil, true, nil
+	}
+	response, found := w.memory.Read(ptr, size)
+	if !found {
+		return nil, true, fmt.Errorf("filter returned %d bytes at %d which is out of memory", size, ptr)
+	}
+	return append([]byte(nil), response...), true, nil // copy since memory is reused by the next call
+}
+
+// make the buffer big enough for the request, growing it in steps so the module allocates rarely
+func (w *Wasm) reserve(ctx context.Context, size uint32) error {
+	if size <= w.capacity && w.capacity != 0 {
+		return nil
+	}
+	if w.capacity != 0 && w.deallocate != nil {
+		if _, err := w.deallocate.Call(ctx, uint64(w.buffer), uint64(w.capacity)); err != nil {
+			return err // untested section
+		}
+	}
+	capacity := max(size, 2*w.capacity, 1024)
+	results, err := w.allocate.Call(ctx, uint64(capacity))
+	if err != nil {
+		return err // untested section
+	}
+	w.buffer, w.capacity = uint32(results[0]), capacity
+	return nil
+}
+
+// a module that failed or was stopped because of the timeout might be in a bad state, so start over
+func (w *Wasm) failed(err error) error {
+	_ = w.module.Close(context.Background())
+	w.module = nil
+	return err
+}
+
+func (w *Wasm) Stop() {
+	_ = w.runtime.Close(context.Background())
+}
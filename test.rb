@@ -42,7 +42,7 @@ describe "logrecycler" do
   end
 
   it "can show version" do
-    call("--version").must_equal "master\n"
+    call("--version").must_match /\Amaster commit=\S+ built=\S+ go=go\S+\n\z/
   end
 
   it "fails fast with unknown arguments" do
@@ -54,19 +54,19 @@ describe "logrecycler" do
   it "fails nicely with no file" do
     with_config "" do
       File.unlink "logrecycler.yaml"
-      call("", expected_exit: 2).must_equal "Error: open logrecycler.yaml: no such file or directory\n"
+      call("", expected_exit: 2).must_equal "Error: logrecycler.yaml: stat logrecycler.yaml: no such file or directory\n"
     end
   end
 
   it "shows location when failing on bad regex in preprocess" do
     with_config "preprocess: '((((WUT'" do
-      call("", expected_exit: 1).must_equal "Error: regular expression from preprocess: error parsing regexp: missing closing ): `((((WUT`"
+      call("", expected_exit: 2).must_equal "Error: logrecycler.yaml: preprocess[0].regex: error parsing regexp: missing closing ): `((((WUT`\n"
     end
   end
 
   it "shows location when failing on bad regex in pattern" do
     with_config "patterns:\n- regex: '((((WUT'" do
-      call("", expected_exit: 1).must_equal "Error: regular expression from patterns[0].regex: error parsing regexp: missing closing ): `((((WUT`"
+      call("", expected_exit: 2).must_equal "Error: logrecycler.yaml: patterns[0].regex: error parsing regexp: missing closing ): `((((WUT`\n"
     end
   end
 